@@ -0,0 +1,112 @@
+package main
+
+// MemoryTotals holds memory accounting figures derived from the mappings of a process.
+type MemoryTotals struct {
+	RssBytes     int64 `json:"rss_bytes"`
+	PssBytes     int64 `json:"pss_bytes"`
+	RssAnonBytes int64 `json:"rss_anon_bytes"`
+	RssFileBytes int64 `json:"rss_file_bytes"`
+	SwapBytes    int64 `json:"swap_bytes"`
+	SwapPssBytes int64 `json:"swap_pss_bytes"`
+
+	// UssBytes is the Unique Set Size: memory private to the process,
+	// which would be freed if the process exited.
+	UssBytes int64 `json:"uss_bytes"`
+
+	// FootprintBytes is the swap-inclusive proportional footprint (PSS + SwapPss).
+	FootprintBytes int64 `json:"footprint_bytes"`
+
+	// ReclaimableBytes estimates the clean file-backed pages the kernel can
+	// drop under memory pressure without writeback or swap.
+	ReclaimableBytes int64 `json:"reclaimable_bytes"`
+}
+
+// ComputeMemoryTotals derives process level accounting figures from its mappings.
+func ComputeMemoryTotals(mappings []*SmapsMapping) MemoryTotals {
+	var t MemoryTotals
+	for _, m := range mappings {
+		t.RssBytes += m.RssBytes
+		t.PssBytes += m.PssBytes
+		t.RssAnonBytes += m.AnonymousBytes
+		t.RssFileBytes += m.RssBytes - m.AnonymousBytes
+		t.SwapBytes += m.SwapBytes
+		t.SwapPssBytes += m.SwapPssBytes
+		t.UssBytes += m.PrivateCleanBytes + m.PrivateDirtyBytes
+		if MappingCategory(m.Path) == CategoryFile {
+			t.ReclaimableBytes += m.SharedCleanBytes + m.PrivateCleanBytes
+		}
+	}
+	t.FootprintBytes = t.PssBytes + t.SwapPssBytes
+	return t
+}
+
+// Add accumulates the totals of another process.
+func (t *MemoryTotals) Add(o MemoryTotals) {
+	t.RssBytes += o.RssBytes
+	t.PssBytes += o.PssBytes
+	t.RssAnonBytes += o.RssAnonBytes
+	t.RssFileBytes += o.RssFileBytes
+	t.SwapBytes += o.SwapBytes
+	t.SwapPssBytes += o.SwapPssBytes
+	t.UssBytes += o.UssBytes
+	t.FootprintBytes += o.FootprintBytes
+	t.ReclaimableBytes += o.ReclaimableBytes
+}
+
+// SumMemoryTotals sums the totals of a group of processes.
+func SumMemoryTotals(processes []*ProcessSnapshot) MemoryTotals {
+	var totals MemoryTotals
+	for _, ps := range processes {
+		totals.Add(ps.Totals)
+	}
+	return totals
+}
+
+// SharedAwareRss returns the RSS of a group of processes, counting the resident
+// file-backed pages of each (dev, inode) object only once. Anonymous pages,
+// including private copies of file pages, are summed since they are not shared.
+func SharedAwareRss(processes []*ProcessSnapshot) int64 {
+	type object struct{ dev, inode string }
+
+	var rss int64
+	fileRss := make(map[object]int64)
+	for _, ps := range processes {
+		for _, m := range ps.Mappings {
+			if m.Inode == "0" {
+				rss += m.RssBytes
+				continue
+			}
+			rss += m.AnonymousBytes
+			key := object{m.Dev, m.Inode}
+			if shared := m.RssBytes - m.AnonymousBytes; shared > fileRss[key] {
+				fileRss[key] = shared
+			}
+		}
+	}
+	for _, shared := range fileRss {
+		rss += shared
+	}
+	return rss
+}
+
+// MappedShmem returns the resident shared memory (shmem) mapped by a group of
+// processes, counting each (dev, inode) object only once.
+func MappedShmem(processes []*ProcessSnapshot) int64 {
+	type object struct{ dev, inode string }
+
+	shmemRss := make(map[object]int64)
+	for _, ps := range processes {
+		for _, m := range ps.Mappings {
+			if MappingCategory(m.Path) != CategoryShmem {
+				continue
+			}
+			key := object{m.Dev, m.Inode}
+			shmemRss[key] = max(shmemRss[key], m.RssBytes-m.AnonymousBytes)
+		}
+	}
+	var rss int64
+	for _, shared := range shmemRss {
+		rss += shared
+	}
+	return rss
+}
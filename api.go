@@ -0,0 +1,252 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mappingSortFields lists the fields mappings can be sorted by, in descending order.
+var mappingSortFields = map[string]func(m *SmapsMapping) int64{
+	"size":          func(m *SmapsMapping) int64 { return m.SizeBytes },
+	"rss":           func(m *SmapsMapping) int64 { return m.RssBytes },
+	"pss":           func(m *SmapsMapping) int64 { return m.PssBytes },
+	"pss_dirty":     func(m *SmapsMapping) int64 { return m.PssDirtyBytes },
+	"shared_clean":  func(m *SmapsMapping) int64 { return m.SharedCleanBytes },
+	"shared_dirty":  func(m *SmapsMapping) int64 { return m.SharedDirtyBytes },
+	"private_clean": func(m *SmapsMapping) int64 { return m.PrivateCleanBytes },
+	"private_dirty": func(m *SmapsMapping) int64 { return m.PrivateDirtyBytes },
+	"referenced":    func(m *SmapsMapping) int64 { return m.ReferencedBytes },
+	"anonymous":     func(m *SmapsMapping) int64 { return m.AnonymousBytes },
+	"swap":          func(m *SmapsMapping) int64 { return m.SwapBytes },
+	"swap_pss":      func(m *SmapsMapping) int64 { return m.SwapPssBytes },
+	"locked":        func(m *SmapsMapping) int64 { return m.LockedBytes },
+}
+
+// MappingQuery selects and orders the mappings of a process.
+type MappingQuery struct {
+	// Aggregate merges the VMAs of each path, as in the metrics.
+	Aggregate bool
+	// Categories keeps only mappings of the listed categories, all when empty.
+	Categories []string
+	// Sort is a key of mappingSortFields, or empty to keep the address order.
+	Sort string
+	// Limit keeps only the first mappings after sorting, all when zero.
+	Limit int
+}
+
+// ParseMappingQuery reads the aggregate, category, sort and limit query parameters.
+func ParseMappingQuery(values url.Values) (MappingQuery, error) {
+	var q MappingQuery
+	var err error
+	if v := values.Get("aggregate"); v != "" {
+		if q.Aggregate, err = strconv.ParseBool(v); err != nil {
+			return q, fmt.Errorf("invalid aggregate %q", v)
+		}
+	}
+	for _, v := range values["category"] {
+		for c := range strings.SplitSeq(v, ",") {
+			if c != "" {
+				q.Categories = append(q.Categories, c)
+			}
+		}
+	}
+	q.Sort = values.Get("sort")
+	if _, ok := mappingSortFields[q.Sort]; q.Sort != "" && !ok {
+		return q, fmt.Errorf("invalid sort %q, expected one of %s", q.Sort, strings.Join(slices.Sorted(maps.Keys(mappingSortFields)), ", "))
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+// Apply returns the mappings of the process selected by the query.
+func (q MappingQuery) Apply(ps *ProcessSnapshot) []*SmapsMapping {
+	if q.Aggregate {
+		return q.Select(ps.Mappings)
+	}
+	return q.Select(ps.VMAs)
+}
+
+// Select filters, sorts and limits the mappings, ignoring Aggregate.
+func (q MappingQuery) Select(mappings []*SmapsMapping) []*SmapsMapping {
+	if len(q.Categories) > 0 {
+		mappings = slices.DeleteFunc(slices.Clone(mappings), func(m *SmapsMapping) bool {
+			return !slices.Contains(q.Categories, MappingCategory(m.Path))
+		})
+	}
+	if field, ok := mappingSortFields[q.Sort]; ok {
+		mappings = slices.Clone(mappings)
+		slices.SortStableFunc(mappings, func(a, b *SmapsMapping) int {
+			return cmp.Compare(field(b), field(a))
+		})
+	}
+	if q.Limit > 0 && len(mappings) > q.Limit {
+		mappings = mappings[:q.Limit]
+	}
+	return mappings
+}
+
+// ProcessReport is the JSON representation of a process and its mappings.
+type ProcessReport struct {
+	Namespace   string          `json:"namespace"`
+	Pod         string          `json:"pod"`
+	Container   string          `json:"container"`
+	ContainerID string          `json:"container_id"`
+	PID         int             `json:"pid"`
+	Comm        string          `json:"comm"`
+	Cmdline     []string        `json:"cmdline"`
+	Totals      MemoryTotals    `json:"totals"`
+	Mappings    []*SmapsMapping `json:"mappings"`
+}
+
+// TargetsReport is the JSON representation of the processes matching a filter.
+type TargetsReport struct {
+	Time      time.Time        `json:"time"`
+	Processes []*ProcessReport `json:"processes"`
+}
+
+// BuildTargetsReport selects the mappings of the snapshots with the query.
+func BuildTargetsReport(snapshots []*ProcessSnapshot, q MappingQuery, now time.Time) *TargetsReport {
+	report := &TargetsReport{Time: now, Processes: []*ProcessReport{}}
+	for _, ps := range snapshots {
+		cmdline, err := readCmdline(ps.Target.PID)
+		if err != nil {
+			slog.Debug("Failed to read command line", "pid", ps.Target.PID, "error", err)
+		}
+		report.Processes = append(report.Processes, &ProcessReport{
+			Namespace:   ps.Target.Namespace,
+			Pod:         ps.Target.Pod,
+			Container:   ps.Target.Container,
+			ContainerID: ps.Target.ContainerID,
+			PID:         ps.Target.PID,
+			Comm:        ps.Comm,
+			Cmdline:     cmdline,
+			Totals:      ps.Totals,
+			Mappings:    q.Apply(ps),
+		})
+	}
+	return report
+}
+
+// requestProcessFilter reads the {namespace}/{pod}/{container} path segments, where * matches
+// any value, and the optional command and pid query parameters. The pid is 0 when not given.
+func requestProcessFilter(r *http.Request) (ProcessFilter, int, error) {
+	filter := ProcessFilter{
+		Namespace: r.PathValue("namespace"),
+		Pod:       r.PathValue("pod"),
+		Container: r.PathValue("container"),
+		Command:   cmp.Or(r.URL.Query().Get("command"), "*"),
+	}
+	var pid int
+	if v := r.URL.Query().Get("pid"); v != "" {
+		var err error
+		if pid, err = strconv.Atoi(v); err != nil {
+			return filter, 0, fmt.Errorf("invalid pid %q", v)
+		}
+	}
+	return filter, pid, nil
+}
+
+// collectMatchingProcesses collects live snapshots of the targets matching filter,
+// and only of the given process when pid is not 0.
+func collectMatchingProcesses(finder Finder, filter ProcessFilter, pid int) ([]*ProcessSnapshot, error) {
+	targets, err := finder.GetTargets(filter)
+	if err != nil {
+		return nil, err
+	}
+	if pid != 0 {
+		targets = slices.DeleteFunc(targets, func(t Target) bool { return t.PID != pid })
+	}
+	return collectProcesses(targets), nil
+}
+
+// collectScopedProcesses is collectMatchingProcesses restricted to the processes
+// within the monitored scope, so that the API does not expose other processes.
+func collectScopedProcesses(finder Finder, scope, filter ProcessFilter, pid int) ([]*ProcessSnapshot, error) {
+	scoped, ok := scope.Intersect(filter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is outside of the monitored processes %s", ErrNoTargets, filter, scope)
+	}
+	return collectMatchingProcesses(finder, scoped, pid)
+}
+
+// targetsErrorStatus returns the HTTP status of an error from finding targets.
+func targetsErrorStatus(err error) int {
+	if errors.Is(err, ErrNoTargets) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// TargetInfo is the JSON representation of a discovered process.
+type TargetInfo struct {
+	Target
+	Comm string `json:"comm"`
+}
+
+// targetListHandler serves /api/v1/targets with the processes within the monitored
+// scope, without reading their smaps.
+func targetListHandler(finder Finder, scope ProcessFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targets, err := finder.GetTargets(scope)
+		if err != nil && !errors.Is(err, ErrNoTargets) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		infos := []TargetInfo{}
+		for _, t := range targets {
+			comm, err := findComm(t.PID)
+			if err != nil {
+				continue
+			}
+			infos = append(infos, TargetInfo{Target: t, Comm: comm})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(infos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// targetsHandler serves /api/v1/targets/{namespace}/{pod}/{container} with the
+// live smaps of the matching processes within the monitored scope.
+func targetsHandler(finder Finder, scope ProcessFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, pid, err := requestProcessFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, err := ParseMappingQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snapshots, err := collectScopedProcesses(finder, scope, filter, pid)
+		if err != nil {
+			http.Error(w, err.Error(), targetsErrorStatus(err))
+			return
+		}
+		if len(snapshots) == 0 {
+			http.Error(w, ErrNoTargets.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(BuildTargetsReport(snapshots, q, time.Now())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
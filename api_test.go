@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeFinder returns a fixed result from GetTargets and records the filter it was called with.
+type fakeFinder struct {
+	targets []Target
+	err     error
+	filter  *ProcessFilter
+}
+
+func (f *fakeFinder) GetTargets(filter ProcessFilter) ([]Target, error) {
+	f.filter = &filter
+	return f.targets, f.err
+}
+
+func (f *fakeFinder) PodAnnotations(string) map[string]string { return nil }
+
+func (f *fakeFinder) GetContainerResources(string) (*ContainerResources, error) {
+	return nil, errors.New("not supported")
+}
+
+// allProcesses is a monitored scope without restrictions.
+var allProcesses = ProcessFilter{Namespace: "*", Pod: "*", Container: "*", Command: "*"}
+
+func TestTargetsHandlerStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		finder *fakeFinder
+		path   string
+		want   int
+	}{
+		{name: "no match", finder: &fakeFinder{err: fmt.Errorf("%w: pod not found", ErrNoTargets)}, path: "/api/v1/targets/default/app-0/app", want: http.StatusNotFound},
+		{name: "pid not found", finder: &fakeFinder{targets: []Target{{PID: 1 << 30}}}, path: "/api/v1/targets/default/app-0/app?pid=1", want: http.StatusNotFound},
+		{name: "runtime failure", finder: &fakeFinder{err: errors.New("connection refused")}, path: "/api/v1/targets/default/app-0/app", want: http.StatusInternalServerError},
+		{name: "invalid pid", finder: &fakeFinder{}, path: "/api/v1/targets/default/app-0/app?pid=x", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v1/targets/{namespace}/{pod}/{container}", targetsHandler(tt.finder, allProcesses))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestTargetListHandlerWithoutTargets(t *testing.T) {
+	rec := httptest.NewRecorder()
+	targetListHandler(&fakeFinder{err: ErrNoTargets}, allProcesses)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Errorf("got status %d and body %q", rec.Code, rec.Body)
+	}
+}
+
+func TestHandlersStayWithinScope(t *testing.T) {
+	scope := ProcessFilter{Namespace: "default", Pod: "*", Container: "app", Command: "*"}
+
+	finder := &fakeFinder{err: ErrNoTargets}
+	targetListHandler(finder, scope)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil))
+	if finder.filter == nil || *finder.filter != scope {
+		t.Errorf("target list got filter %v, want %v", finder.filter, scope)
+	}
+
+	tests := []struct {
+		path string
+		want *ProcessFilter
+	}{
+		{path: "/api/v1/targets/*/app-0/*", want: &ProcessFilter{Namespace: "default", Pod: "app-0", Container: "app", Command: "*"}},
+		{path: "/api/v1/targets/kube-system/*/*", want: nil},
+		{path: "/api/v1/targets/default/app-0/sidecar", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			finder := &fakeFinder{err: ErrNoTargets}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v1/targets/{namespace}/{pod}/{container}", targetsHandler(finder, scope))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if !reflect.DeepEqual(finder.filter, tt.want) {
+				t.Errorf("got filter %v, want %v", finder.filter, tt.want)
+			}
+		})
+	}
+}
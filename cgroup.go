@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CgroupMemory holds the memory accounting of a cgroup v2 group.
+type CgroupMemory struct {
+	Path string
+
+	CurrentBytes int64
+
+	// MaxBytes and HighBytes are the memory.max and memory.high limits, math.MaxInt64 when unlimited.
+	MaxBytes  int64
+	HighBytes int64
+
+	// MinBytes is the memory.min protection, set by the kubelet from the memory request when MemoryQoS is enabled.
+	MinBytes int64
+
+	// Stat and Events hold the key-value pairs of memory.stat and memory.events.
+	Stat   map[string]int64
+	Events map[string]int64
+}
+
+// UnexplainedMemory splits the memory charged to a cgroup that is not explained by the mappings of its processes.
+type UnexplainedMemory struct {
+	TotalBytes     int64
+	KernelBytes    int64
+	PageCacheBytes int64
+	ShmemBytes     int64
+	SockBytes      int64
+}
+
+// findCgroupPath returns the cgroup v2 path of a process by reading /proc/<pid>/cgroup.
+func findCgroupPath(pid int) (string, error) {
+	f, err := os.Open(filepath.Join(*procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The cgroup v2 hierarchy is listed as '0::/kubepods.slice/...'
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, found := strings.CutPrefix(scanner.Text(), "0::"); found {
+			return path, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cgroup v2 hierarchy not found for pid %d", pid)
+}
+
+// ReadCgroupMemory reads memory.current, the limits, memory.stat and memory.events from a cgroup directory.
+func ReadCgroupMemory(dir string) (*CgroupMemory, error) {
+	cg := &CgroupMemory{Path: dir}
+
+	var err error
+	if cg.CurrentBytes, err = readCgroupValue(filepath.Join(dir, "memory.current")); err != nil {
+		return nil, err
+	}
+	if cg.MaxBytes, err = readCgroupValue(filepath.Join(dir, "memory.max")); err != nil {
+		return nil, err
+	}
+	if cg.HighBytes, err = readCgroupValue(filepath.Join(dir, "memory.high")); err != nil {
+		return nil, err
+	}
+	if cg.MinBytes, err = readCgroupValue(filepath.Join(dir, "memory.min")); err != nil {
+		return nil, err
+	}
+	if cg.Stat, err = readCgroupKeyValues(filepath.Join(dir, "memory.stat")); err != nil {
+		return nil, err
+	}
+	if cg.Events, err = readCgroupKeyValues(filepath.Join(dir, "memory.events")); err != nil {
+		return nil, err
+	}
+	return cg, nil
+}
+
+// readCgroupValue reads a single value cgroup file. The value "max" is returned as math.MaxInt64.
+func readCgroupValue(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return math.MaxInt64, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// readCgroupKeyValues reads a flat keyed cgroup file such as memory.stat.
+func readCgroupKeyValues(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCgroupKeyValues(f)
+}
+
+// ParseCgroupKeyValues parses the contents of a flat keyed cgroup file, one "key value" pair per line.
+func ParseCgroupKeyValues(r io.Reader) (map[string]int64, error) {
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		val, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+	return values, nil
+}
+
+// ExplainCgroupMemory computes how much of the memory charged to the cgroup is
+// not explained by the mappings of the given processes.
+//
+// Kernel memory and socket buffers are never mapped. Page cache is the file
+// memory that is not mapped by any process, excluding shmem, which is reported
+// separately as the shmem not mapped by the processes.
+func ExplainCgroupMemory(cg *CgroupMemory, processes []*ProcessSnapshot) UnexplainedMemory {
+	var u UnexplainedMemory
+
+	u.TotalBytes = max(0, cg.CurrentBytes-SharedAwareRss(processes))
+
+	if kernel, found := cg.Stat["kernel"]; found {
+		u.KernelBytes = kernel
+	} else {
+		// Kernels before 5.18 do not report the total.
+		u.KernelBytes = cg.Stat["kernel_stack"] + cg.Stat["pagetables"] + cg.Stat["percpu"] + cg.Stat["slab"]
+	}
+
+	u.ShmemBytes = max(0, cg.Stat["shmem"]-MappedShmem(processes))
+	u.PageCacheBytes = max(0, cg.Stat["file"]-cg.Stat["file_mapped"]-u.ShmemBytes)
+	u.SockBytes = cg.Stat["sock"]
+
+	return u
+}
+
+// readContainerCgroup reads the cgroup memory accounting of the container the processes run in.
+func readContainerCgroup(processes []*ProcessSnapshot) (*CgroupMemory, error) {
+	if len(processes) == 0 {
+		return nil, fmt.Errorf("no processes")
+	}
+	dir, err := findContainerCgroupDir(processes[0].Target)
+	if err != nil {
+		return nil, err
+	}
+	return ReadCgroupMemory(dir)
+}
+
+var (
+	cgroupDirCacheMu sync.Mutex
+	cgroupDirCache   = make(map[string]string)
+)
+
+// findContainerCgroupDir returns the cgroup directory of the container the target runs in.
+//
+// The path in /proc/<pid>/cgroup is relative to the cgroup namespace of the
+// reader, so it only resolves when the exporter runs in the host cgroup
+// namespace. Otherwise the hierarchy is searched for a directory named after
+// the container ID, as created by both the cgroupfs and systemd cgroup drivers.
+func findContainerCgroupDir(t Target) (string, error) {
+	cgroupDirCacheMu.Lock()
+	defer cgroupDirCacheMu.Unlock()
+
+	if dir, found := cgroupDirCache[t.ContainerID]; found && isCgroupDir(dir) {
+		return dir, nil
+	}
+
+	path, err := findCgroupPath(t.PID)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(*cgroupPath, path)
+	if strings.HasPrefix(path, "/..") || !isCgroupDir(dir) {
+		dir, err = searchCgroupDir(*cgroupPath, t.ContainerID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	cgroupDirCache[t.ContainerID] = dir
+	return dir, nil
+}
+
+// pruneCgroupDirCache removes containers that are no longer running from the cgroup directory cache.
+func pruneCgroupDirCache(targets []Target) {
+	running := make(map[string]bool)
+	for _, t := range targets {
+		running[t.ContainerID] = true
+	}
+
+	cgroupDirCacheMu.Lock()
+	defer cgroupDirCacheMu.Unlock()
+	for containerID := range cgroupDirCache {
+		if !running[containerID] {
+			delete(cgroupDirCache, containerID)
+		}
+	}
+}
+
+// isCgroupDir checks if dir is a cgroup v2 directory with the memory controller enabled.
+func isCgroupDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "memory.current"))
+	return err == nil
+}
+
+// searchCgroupDir walks the cgroup hierarchy looking for the directory of the given container ID.
+func searchCgroupDir(root, containerID string) (string, error) {
+	var found string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if name == containerID || strings.HasSuffix(name, "-"+containerID+".scope") {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("cgroup of container %s not found under %s", containerID, root)
+	}
+	return found, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ProcessSnapshot holds the data collected from one process during a poll.
+type ProcessSnapshot struct {
+	Target Target
+	Comm   string
+
+	// VMAs lists every VMA of the process, Mappings the same data aggregated by path.
+	VMAs     []*SmapsMapping
+	Mappings []*SmapsMapping
+
+	Totals MemoryTotals
+	Status *ProcessStatus
+
+	// KSM holds the kernel samepage merging counters, nil when not supported by the kernel.
+	KSM *KSMStat
+
+	// Numa holds the NUMA placement of the mappings keyed by path, when enabled.
+	Numa map[string]*NumaStats
+
+	// PageStats holds the page level analysis of selected mappings keyed by path, when enabled.
+	PageStats map[string]*PageStats
+
+	// WorkingSet holds the working set estimate of selected mappings keyed by path, when enabled.
+	WorkingSet map[string]*WorkingSet
+
+	// DirtyRate holds the bytes written per second by mapping category, when soft-dirty tracking is enabled.
+	DirtyRate map[string]float64
+
+	// Growth holds the growth trend of the mappings keyed by path, when leak detection is enabled.
+	Growth map[string]*MappingGrowth
+}
+
+// latestSnapshots holds the snapshots of the most recent poll, for analyses running in the background.
+var latestSnapshots atomic.Pointer[[]*ProcessSnapshot]
+
+// collectProcesses collects a snapshot of each target.
+// Targets that cannot be read, e.g. because the process has exited, are skipped.
+func collectProcesses(targets []Target) []*ProcessSnapshot {
+	var snapshots []*ProcessSnapshot
+	for _, t := range targets {
+		slog.Debug("Processing smaps for", "pid", t.PID, "namespace", t.Namespace, "pod", t.Pod, "container", t.Container)
+		s, err := collectProcess(t)
+		if err != nil {
+			slog.Error("Failed to collect process", "pid", t.PID, "error", err)
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots
+}
+
+// collectProcess reads /proc/<pid>/smaps and comm of the target.
+func collectProcess(t Target) (*ProcessSnapshot, error) {
+	comm, err := findComm(t.PID)
+	if err != nil {
+		return nil, err
+	}
+	smapsPath := filepath.Join(*procPath, strconv.Itoa(t.PID), "smaps")
+	f, err := os.Open(smapsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vmas, err := ParseSmapsVMAs(f)
+	if err != nil {
+		return nil, err
+	}
+	status, err := readStatus(t.PID)
+	if err != nil {
+		return nil, err
+	}
+	// The KSM counters are optional, so a failure to read them does not drop the process.
+	ksm, err := readKSMStat(t.PID)
+	if err != nil {
+		slog.Warn("Failed to read KSM counters, continuing without KSM data", "pid", t.PID, "error", err)
+		ksm = nil
+	}
+	// The NUMA placement is optional, e.g. numa_maps is missing on kernels without CONFIG_NUMA.
+	var numa map[string]*NumaStats
+	if *numaMaps {
+		numa, err = readNumaMaps(t.PID, vmas)
+		if err != nil {
+			slog.Warn("Failed to read NUMA maps, continuing without NUMA data", "pid", t.PID, "error", err)
+			numa = nil
+		}
+	}
+	mappings := AggregateSmaps(vmas)
+	return &ProcessSnapshot{
+		Target:   t,
+		Comm:     comm,
+		VMAs:     vmas,
+		Mappings: mappings,
+		Totals:   ComputeMemoryTotals(mappings),
+		Status:   status,
+		KSM:      ksm,
+		Numa:     numa,
+	}, nil
+}
+
+// readKSMStat reads /proc/<pid>/ksm_stat and /proc/<pid>/ksm_merging_pages.
+// It returns nil if the kernel provides neither file.
+func readKSMStat(pid int) (*KSMStat, error) {
+	pidPath := filepath.Join(*procPath, strconv.Itoa(pid))
+
+	var stat *KSMStat
+	f, err := os.Open(filepath.Join(pidPath, "ksm_stat"))
+	if err == nil {
+		defer f.Close()
+		if stat, err = ParseKSMStat(f); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// ksm_merging_pages predates ksm_stat.
+	data, err := os.ReadFile(filepath.Join(pidPath, "ksm_merging_pages"))
+	if errors.Is(err, os.ErrNotExist) {
+		return stat, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pages, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if stat == nil {
+		stat = &KSMStat{}
+	}
+	stat.MergingPages = pages
+	return stat, nil
+}
+
+// readNumaMaps reads /proc/<pid>/numa_maps and joins it to the VMAs of the process.
+func readNumaMaps(pid int, vmas []*SmapsMapping) (map[string]*NumaStats, error) {
+	f, err := os.Open(filepath.Join(*procPath, strconv.Itoa(pid), "numa_maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	numa, err := ParseNumaMaps(f)
+	if err != nil {
+		return nil, err
+	}
+	return JoinNumaMaps(vmas, numa), nil
+}
+
+// readStatus reads /proc/<pid>/status and /proc/<pid>/statm.
+func readStatus(pid int) (*ProcessStatus, error) {
+	pidPath := filepath.Join(*procPath, strconv.Itoa(pid))
+	f, err := os.Open(filepath.Join(pidPath, "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	status, err := ParseStatus(f)
+	if err != nil {
+		return nil, err
+	}
+
+	statm, err := os.Open(filepath.Join(pidPath, "statm"))
+	if err != nil {
+		return nil, err
+	}
+	defer statm.Close()
+	if err := ParseStatm(statm, int64(os.Getpagesize()), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// scanPages runs the page level analysis on the mappings of the processes, starting from
+// the process at index first and wrapping around. It returns the index of the process to
+// start from in the next poll, so that the budget rotates between the processes.
+// Processes left out keep the series of their previous analysis.
+func scanPages(snapshots []*ProcessSnapshot, scanner *PageScanner, first int) int {
+	for i := range snapshots {
+		index := (first + i) % len(snapshots)
+		ps := snapshots[index]
+		stats, err := scanner.ScanProcess(ps.Target.PID, ps.VMAs)
+		ps.PageStats = stats
+		if errors.Is(err, errPageScanBudget) {
+			slog.Warn("Page scan budget exhausted, remaining mappings are analyzed in the next poll", "pid", ps.Target.PID)
+			// A process that exhausts the budget alone is skipped next time, so that it does not block the others.
+			if i == 0 {
+				return (index + 1) % len(snapshots)
+			}
+			return index
+		}
+		if err != nil {
+			slog.Error("Failed to scan pages", "pid", ps.Target.PID, "error", err)
+		}
+	}
+	return first
+}
+
+// groupByContainer groups process snapshots by the container they run in.
+func groupByContainer(snapshots []*ProcessSnapshot) map[ContainerRef][]*ProcessSnapshot {
+	containers := make(map[ContainerRef][]*ProcessSnapshot)
+	for _, s := range snapshots {
+		ref := s.Target.ContainerRef()
+		containers[ref] = append(containers[ref], s)
+	}
+	return containers
+}
+
+// groupByPod groups process snapshots by the pod they run in.
+func groupByPod(snapshots []*ProcessSnapshot) map[PodRef][]*ProcessSnapshot {
+	pods := make(map[PodRef][]*ProcessSnapshot)
+	for _, s := range snapshots {
+		ref := s.Target.PodRef()
+		pods[ref] = append(pods[ref], s)
+	}
+	return pods
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectProcessWithoutOptionalFiles(t *testing.T) {
+	dir := t.TempDir()
+	pidDir := filepath.Join(dir, "42")
+	files := map[string]string{
+		"comm":   "java\n",
+		"smaps":  "55d4a8a00000-55d4a8a21000 rw-p 00000000 00:00 0                          [heap]\nSize:                132 kB\nRss:                   8 kB\nPss:                   8 kB\n",
+		"status": "Name:\tjava\nVmRSS:\t       8 kB\n",
+		"statm":  "33 2 0 1 0 2 0\n",
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(pidDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pidDir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories in place of the optional files fail to be read.
+	for _, name := range []string{"numa_maps", "ksm_stat"} {
+		if err := os.Mkdir(filepath.Join(pidDir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedProcPath, savedNumaMaps := *procPath, *numaMaps
+	t.Cleanup(func() { *procPath, *numaMaps = savedProcPath, savedNumaMaps })
+	*procPath, *numaMaps = dir, true
+
+	ps, err := collectProcess(Target{PID: 42})
+	if err != nil {
+		t.Fatalf("process dropped because of optional files: %v", err)
+	}
+	if ps.Numa != nil || ps.KSM != nil {
+		t.Errorf("got NUMA %v and KSM %v, want none", ps.Numa, ps.KSM)
+	}
+	if ps.Comm != "java" || ps.Totals.RssBytes != 8*1024 {
+		t.Errorf("got comm %q and RSS %d", ps.Comm, ps.Totals.RssBytes)
+	}
+}
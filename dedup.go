@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DuplicateStats holds the results of the duplicate content analysis of a mapping.
+// All figures are counted among the sampled pages only.
+type DuplicateStats struct {
+	SampledBytes int64
+
+	// ZeroBytes are pages filled with zeros.
+	ZeroBytes int64
+
+	// DuplicateBytes are pages whose content appears earlier in the same process.
+	DuplicateBytes int64
+
+	// CrossContainerBytes are pages whose content also appears in another container,
+	// i.e. the potential savings of kernel samepage merging across containers.
+	CrossContainerBytes int64
+}
+
+// pageHash is a truncated SHA-256 hash of the content of a page.
+type pageHash [16]byte
+
+// sampledPage is the hash of a sampled page and the mapping it belongs to.
+type sampledPage struct {
+	hash  pageHash
+	stats *DuplicateStats
+}
+
+// DuplicateAnalyzer samples pages of anonymous mappings through /proc/[pid]/mem
+// and detects zero-filled and duplicate pages by their hashes.
+//
+// Page contents are read into a single buffer that is overwritten for every
+// page and cleared at the end of a cycle; only the hashes are kept.
+// Reading /proc/[pid]/mem requires CAP_SYS_PTRACE.
+type DuplicateAnalyzer struct {
+	procPath       string
+	maxPages       int64
+	pagesPerSecond float64
+	pageSize       int64
+
+	// previous holds the label values of the series set by the previous cycle.
+	previous map[string][]string
+}
+
+// NewDuplicateAnalyzer creates an analyzer that samples at most maxPages pages per cycle,
+// reading at most pagesPerSecond pages per second.
+func NewDuplicateAnalyzer(procPath string, maxPages int64, pagesPerSecond float64) *DuplicateAnalyzer {
+	return &DuplicateAnalyzer{
+		procPath:       procPath,
+		maxPages:       maxPages,
+		pagesPerSecond: pagesPerSecond,
+		pageSize:       int64(os.Getpagesize()),
+		previous:       make(map[string][]string),
+	}
+}
+
+// Run analyzes the latest snapshots collected by pollMetrics once per interval.
+func (a *DuplicateAnalyzer) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+
+		snapshots := latestSnapshots.Load()
+		if snapshots == nil {
+			continue
+		}
+		results := a.Analyze(*snapshots)
+		a.setMetrics(*snapshots, results)
+	}
+}
+
+// Analyze samples the present pages of the anonymous mappings of the processes.
+// It returns the statistics of each process keyed by target and path.
+//
+// The pagemap of the mappings is walked to find the present pages. Every 4 KiB
+// of pagemap read counts as one page against the budget and the rate limit,
+// like the sampled pages, so that large sparse mappings do not escape the limits.
+func (a *DuplicateAnalyzer) Analyze(snapshots []*ProcessSnapshot) map[Target]map[string]*DuplicateStats {
+	present, chunks := a.estimatePages(snapshots)
+	if present == 0 {
+		return nil
+	}
+	// Every n-th present page is sampled to spread the budget left after walking
+	// the pagemap evenly over all mappings.
+	samplesBudget := max(a.maxPages-chunks, 1)
+	stride := int64(math.Ceil(float64(present) / float64(samplesBudget)))
+
+	buf := make([]byte, a.pageSize)
+	defer clear(buf)
+
+	results := make(map[Target]map[string]*DuplicateStats)
+	owners := make(map[pageHash]ContainerRef)
+	crossContainer := make(map[pageHash]bool)
+	var samples []sampledPage
+	start := time.Now()
+	var index, read int64
+	charge := func() error {
+		if read >= a.maxPages {
+			return errPageScanBudget
+		}
+		read++
+		throttlePages(start, read, a.pagesPerSecond)
+		return nil
+	}
+	for _, ps := range snapshots {
+		byPath := make(map[string]*DuplicateStats)
+		results[ps.Target] = byPath
+		seen := make(map[pageHash]bool)
+		ref := ps.Target.ContainerRef()
+
+		mem, err := os.Open(filepath.Join(a.procPath, strconv.Itoa(ps.Target.PID), "mem"))
+		if err != nil {
+			slog.Debug("Failed to open process memory", "pid", ps.Target.PID, "error", err)
+			continue
+		}
+		err = a.forEachPresentPage(ps, charge, func(path string, addr uint64) error {
+			index++
+			if index%stride != 0 {
+				return nil
+			}
+			if err := charge(); err != nil {
+				return err
+			}
+
+			stats, found := byPath[path]
+			if !found {
+				stats = &DuplicateStats{}
+				byPath[path] = stats
+			}
+			if hash, ok := hashPage(mem, addr, buf); ok {
+				stats.SampledBytes += a.pageSize
+				switch {
+				case isZeroPage(buf):
+					stats.ZeroBytes += a.pageSize
+					return nil
+				case seen[hash]:
+					stats.DuplicateBytes += a.pageSize
+				}
+				seen[hash] = true
+				if owner, found := owners[hash]; !found {
+					owners[hash] = ref
+				} else if owner != ref {
+					crossContainer[hash] = true
+				}
+				samples = append(samples, sampledPage{hash: hash, stats: stats})
+			}
+			return nil
+		})
+		mem.Close()
+		if errors.Is(err, errPageScanBudget) {
+			break
+		}
+		if err != nil {
+			slog.Debug("Failed to sample pages", "pid", ps.Target.PID, "error", err)
+		}
+	}
+
+	for _, s := range samples {
+		if crossContainer[s.hash] {
+			s.stats.CrossContainerBytes += a.pageSize
+		}
+	}
+	return results
+}
+
+// isSampledMapping checks if the mapping is anonymous memory analyzed by the duplicate content analysis.
+func isSampledMapping(vma *SmapsMapping) bool {
+	switch MappingCategory(vma.Path) {
+	case CategoryAnon, CategoryHeap, CategoryStack:
+		return true
+	}
+	return false
+}
+
+// estimatePages estimates the present pages of the anonymous mappings of all
+// processes from their resident size in smaps, and counts the pagemap chunks
+// walked to find them.
+func (a *DuplicateAnalyzer) estimatePages(snapshots []*ProcessSnapshot) (present, chunks int64) {
+	for _, ps := range snapshots {
+		for _, vma := range ps.VMAs {
+			if isSampledMapping(vma) {
+				present += vma.RssBytes / a.pageSize
+				pages := vma.SizeBytes / a.pageSize
+				chunks += (pages + pagemapChunkEntries - 1) / pagemapChunkEntries
+			}
+		}
+	}
+	return present, chunks
+}
+
+// forEachPresentPage calls fn with the path and address of each present page of the anonymous mappings of the process.
+// The pagemap is read in chunks, and charge is called for each chunk read.
+func (a *DuplicateAnalyzer) forEachPresentPage(ps *ProcessSnapshot, charge func() error, fn func(path string, addr uint64) error) error {
+	pagemap, err := os.Open(filepath.Join(a.procPath, strconv.Itoa(ps.Target.PID), "pagemap"))
+	if err != nil {
+		return err
+	}
+	defer pagemap.Close()
+
+	for _, vma := range ps.VMAs {
+		if !isSampledMapping(vma) {
+			continue
+		}
+		start, end, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		err = walkPagemap(pagemap, start, end, a.pageSize, func(addr uint64, entries []uint64) error {
+			if err := charge(); err != nil {
+				return err
+			}
+			for i, e := range entries {
+				if e&pagemapPresent == 0 {
+					continue
+				}
+				if err := fn(vma.Path, addr+uint64(int64(i)*a.pageSize)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashPage reads a page from /proc/<pid>/mem into buf and returns its hash.
+func hashPage(mem io.ReaderAt, addr uint64, buf []byte) (pageHash, bool) {
+	if _, err := mem.ReadAt(buf, int64(addr)); err != nil {
+		return pageHash{}, false
+	}
+	sum := sha256.Sum256(buf)
+	var hash pageHash
+	copy(hash[:], sum[:])
+	return hash, true
+}
+
+// isZeroPage checks if the page is filled with zeros.
+func isZeroPage(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setMetrics exports the results and deletes the series of mappings not present anymore.
+func (a *DuplicateAnalyzer) setMetrics(snapshots []*ProcessSnapshot, results map[Target]map[string]*DuplicateStats) {
+	current := make(map[string][]string)
+	for _, ps := range snapshots {
+		for path, stats := range results[ps.Target] {
+			labels := append(processLabelValues(ps.Target, ps.Comm), path)
+			ProcessDuplicateSampled.WithLabelValues(labels...).Set(float64(stats.SampledBytes))
+			ProcessDuplicateZero.WithLabelValues(labels...).Set(float64(stats.ZeroBytes))
+			ProcessDuplicateWithinProcess.WithLabelValues(labels...).Set(float64(stats.DuplicateBytes))
+			ProcessDuplicateCrossContainer.WithLabelValues(labels...).Set(float64(stats.CrossContainerBytes))
+			current[strings.Join(labels, "\x00")] = labels
+		}
+	}
+	for key, labels := range a.previous {
+		if _, found := current[key]; !found {
+			ProcessDuplicateSampled.DeleteLabelValues(labels...)
+			ProcessDuplicateZero.DeleteLabelValues(labels...)
+			ProcessDuplicateWithinProcess.DeleteLabelValues(labels...)
+			ProcessDuplicateCrossContainer.DeleteLabelValues(labels...)
+		}
+	}
+	a.previous = current
+}
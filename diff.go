@@ -0,0 +1,266 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Statuses of a mapping in a snapshot diff.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// DiffValues holds the values compared between snapshots.
+type DiffValues struct {
+	RssBytes          int64 `json:"rss_bytes"`
+	PssBytes          int64 `json:"pss_bytes"`
+	PrivateDirtyBytes int64 `json:"private_dirty_bytes"`
+}
+
+func (v *DiffValues) add(o DiffValues) {
+	v.RssBytes += o.RssBytes
+	v.PssBytes += o.PssBytes
+	v.PrivateDirtyBytes += o.PrivateDirtyBytes
+}
+
+// maxAbsDelta returns the largest absolute difference between the values.
+func (v DiffValues) maxAbsDelta(o DiffValues) int64 {
+	return max(abs(o.RssBytes-v.RssBytes), abs(o.PssBytes-v.PssBytes), abs(o.PrivateDirtyBytes-v.PrivateDirtyBytes))
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// MappingDiff compares a path between snapshots.
+type MappingDiff struct {
+	Path     string     `json:"path"`
+	Category string     `json:"category"`
+	Status   string     `json:"status"`
+	Old      DiffValues `json:"old"`
+	New      DiffValues `json:"new"`
+}
+
+// CategoryDiff compares the total of a mapping category between snapshots.
+type CategoryDiff struct {
+	Category string     `json:"category"`
+	Old      DiffValues `json:"old"`
+	New      DiffValues `json:"new"`
+}
+
+// ContainerDiff compares a container between snapshots.
+type ContainerDiff struct {
+	Namespace  string         `json:"namespace"`
+	Container  string         `json:"container"`
+	Old        DiffValues     `json:"old"`
+	New        DiffValues     `json:"new"`
+	Categories []CategoryDiff `json:"categories"`
+	Mappings   []MappingDiff  `json:"mappings"`
+}
+
+// SnapshotDiff is the result of comparing two snapshots.
+type SnapshotDiff struct {
+	OldTime    time.Time        `json:"old_time"`
+	NewTime    time.Time        `json:"new_time"`
+	Containers []*ContainerDiff `json:"containers"`
+}
+
+// snapshotContainer holds the mappings of a container in a snapshot, summed by path over its processes.
+type snapshotContainer struct {
+	paths map[string]*DiffValues
+}
+
+// groupSnapshot sums the mappings of the snapshot by container and path.
+// Containers are identified by namespace and container name, since pod names
+// usually change between the releases being compared.
+func groupSnapshot(s *Snapshot) map[[2]string]*snapshotContainer {
+	containers := make(map[[2]string]*snapshotContainer)
+	for _, p := range s.Processes {
+		key := [2]string{p.Namespace, p.Container}
+		c, ok := containers[key]
+		if !ok {
+			c = &snapshotContainer{paths: make(map[string]*DiffValues)}
+			containers[key] = c
+		}
+		for _, m := range p.Mappings {
+			v, ok := c.paths[m.Path]
+			if !ok {
+				v = &DiffValues{}
+				c.paths[m.Path] = v
+			}
+			v.add(DiffValues{RssBytes: m.RssBytes, PssBytes: m.PssBytes, PrivateDirtyBytes: m.PrivateDirtyBytes})
+		}
+	}
+	return containers
+}
+
+// DiffSnapshots compares two snapshots. Mappings whose values changed by less
+// than threshold bytes, and added or removed mappings smaller than threshold,
+// are left out. Category and container totals include all mappings.
+func DiffSnapshots(before, after *Snapshot, threshold int64) *SnapshotDiff {
+	oldContainers := groupSnapshot(before)
+	newContainers := groupSnapshot(after)
+	keys := slices.Collect(maps.Keys(oldContainers))
+	for key := range newContainers {
+		if _, ok := oldContainers[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int {
+		return cmp.Or(strings.Compare(a[0], b[0]), strings.Compare(a[1], b[1]))
+	})
+
+	diff := &SnapshotDiff{OldTime: before.Time, NewTime: after.Time, Containers: []*ContainerDiff{}}
+	empty := &snapshotContainer{paths: map[string]*DiffValues{}}
+	for _, key := range keys {
+		oc := cmp.Or(oldContainers[key], empty)
+		nc := cmp.Or(newContainers[key], empty)
+		cd := &ContainerDiff{Namespace: key[0], Container: key[1], Categories: []CategoryDiff{}, Mappings: []MappingDiff{}}
+
+		categories := make(map[string]*CategoryDiff)
+		paths := slices.Collect(maps.Keys(oc.paths))
+		for path := range nc.paths {
+			if _, ok := oc.paths[path]; !ok {
+				paths = append(paths, path)
+			}
+		}
+		for _, path := range paths {
+			md := MappingDiff{Path: path, Category: MappingCategory(path), Status: DiffChanged}
+			if v, ok := oc.paths[path]; ok {
+				md.Old = *v
+			} else {
+				md.Status = DiffAdded
+			}
+			if v, ok := nc.paths[path]; ok {
+				md.New = *v
+			} else {
+				md.Status = DiffRemoved
+			}
+
+			c, ok := categories[md.Category]
+			if !ok {
+				c = &CategoryDiff{Category: md.Category}
+				categories[md.Category] = c
+			}
+			c.Old.add(md.Old)
+			c.New.add(md.New)
+			cd.Old.add(md.Old)
+			cd.New.add(md.New)
+
+			if md.Old.maxAbsDelta(md.New) >= threshold {
+				cd.Mappings = append(cd.Mappings, md)
+			}
+		}
+		slices.SortFunc(cd.Mappings, func(a, b MappingDiff) int {
+			return cmp.Or(cmp.Compare(b.Old.maxAbsDelta(b.New), a.Old.maxAbsDelta(a.New)), strings.Compare(a.Path, b.Path))
+		})
+		for _, category := range slices.Sorted(maps.Keys(categories)) {
+			cd.Categories = append(cd.Categories, *categories[category])
+		}
+		diff.Containers = append(diff.Containers, cd)
+	}
+	return diff
+}
+
+// diffCommand implements the diff subcommand, which compares two snapshot files.
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	output := fs.String("o", OutputText, "Output format: text or json")
+	threshold := fs.Int64("threshold", 100*1024, "Hide mappings whose RSS, PSS and private dirty changed by less than this many bytes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] diff [diff flags] <old snapshot> <new snapshot>\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Compares two snapshots written by the snapshot command.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	before, err := readSnapshotFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	after, err := readSnapshotFile(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	diff := DiffSnapshots(before, after, *threshold)
+
+	switch *output {
+	case OutputText:
+		err = writeDiffText(os.Stdout, diff)
+	case OutputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(diff)
+	default:
+		err = fmt.Errorf("unsupported output format %q", *output)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func writeDiffText(w io.Writer, diff *SnapshotDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Comparing %s with %s, values in kB\n", diff.OldTime.Format(time.RFC3339), diff.NewTime.Format(time.RFC3339))
+	header := "\tRss old\tRss new\tDelta\tPss old\tPss new\tDelta\tPrivate_Dirty old\tPrivate_Dirty new\tDelta\t"
+	for _, cd := range diff.Containers {
+		fmt.Fprintf(tw, "\nContainer %s/%s\n", cd.Namespace, cd.Container)
+		fmt.Fprintln(tw, "Category"+header)
+		for _, c := range cd.Categories {
+			fmt.Fprintln(tw, c.Category+"\t"+formatDiffValues(c.Old, c.New))
+		}
+		fmt.Fprintln(tw, "Total\t"+formatDiffValues(cd.Old, cd.New))
+
+		if len(cd.Mappings) == 0 {
+			continue
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Status"+header+" Mapping")
+		for _, md := range cd.Mappings {
+			fmt.Fprintln(tw, md.Status+"\t"+formatDiffValues(md.Old, md.New)+" "+md.Path)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatDiffValues formats the old and new values and their difference as tab separated cells in kB.
+func formatDiffValues(before, after DiffValues) string {
+	var cells []string
+	for _, pair := range [][2]int64{
+		{before.RssBytes, after.RssBytes},
+		{before.PssBytes, after.PssBytes},
+		{before.PrivateDirtyBytes, after.PrivateDirtyBytes},
+	} {
+		delta := strconv.FormatInt((pair[1]-pair[0])/1024, 10)
+		if pair[1] > pair[0] {
+			delta = "+" + delta
+		}
+		cells = append(cells, strconv.FormatInt(pair[0]/1024, 10), strconv.FormatInt(pair[1]/1024, 10), delta)
+	}
+	return strings.Join(cells, "\t") + "\t"
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyCategories lists the mapping categories kept in the history, in storage order.
+var historyCategories = [...]string{CategoryHeap, CategoryStack, CategoryAnon, CategoryFile, CategoryShmem, CategoryDevice, CategorySpecial}
+
+// HistoryValues holds the memory of a mapping category at one point in time.
+type HistoryValues struct {
+	RssBytes  int64 `json:"rss_bytes"`
+	PssBytes  int64 `json:"pss_bytes"`
+	UssBytes  int64 `json:"uss_bytes"`
+	SwapBytes int64 `json:"swap_bytes"`
+}
+
+// HistorySample is the memory of a process by mapping category at one poll.
+// Categories without memory are left out.
+type HistorySample struct {
+	Time       time.Time                `json:"time"`
+	Categories map[string]HistoryValues `json:"categories"`
+}
+
+// TargetHistory holds the samples of a process.
+type TargetHistory struct {
+	Target
+	Comm string `json:"comm"`
+	// Running is false when the process was not seen in the latest poll, e.g. because it was OOM-killed.
+	Running bool            `json:"running"`
+	Samples []HistorySample `json:"samples"`
+}
+
+// HistoryReport is the result of a history query.
+type HistoryReport struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Targets []*TargetHistory `json:"targets"`
+}
+
+// historyEntry is the compact form of a HistorySample kept in memory.
+type historyEntry struct {
+	time   time.Time
+	values [len(historyCategories)]HistoryValues
+}
+
+// historyRing holds the most recent entries of a process in a circular buffer,
+// count entries from start, oldest first.
+type historyRing struct {
+	comm    string
+	running bool
+	entries []historyEntry
+	start   int
+	count   int
+}
+
+func (r *historyRing) at(i int) *historyEntry {
+	return &r.entries[(r.start+i)%len(r.entries)]
+}
+
+// push appends the entry, overwriting the oldest one when the ring holds capacity entries.
+// The buffer grows up to capacity as needed.
+func (r *historyRing) push(e historyEntry, capacity int) {
+	switch {
+	case r.count < len(r.entries):
+		*r.at(r.count) = e
+		r.count++
+	case r.count < capacity:
+		entries := make([]historyEntry, min(capacity, max(2*r.count, 16)))
+		for i := range r.count {
+			entries[i] = *r.at(i)
+		}
+		entries[r.count] = e
+		r.entries, r.start = entries, 0
+		r.count++
+	default:
+		r.entries[r.start] = e
+		r.start = (r.start + 1) % len(r.entries)
+	}
+}
+
+// dropBefore removes the entries older than cutoff.
+func (r *historyRing) dropBefore(cutoff time.Time) {
+	n := sort.Search(r.count, func(i int) bool { return !r.at(i).time.Before(cutoff) })
+	if n == r.count {
+		*r = historyRing{comm: r.comm, running: r.running}
+		return
+	}
+	r.start = (r.start + n) % len(r.entries)
+	r.count -= n
+}
+
+// between returns the samples taken from from to to, both inclusive.
+func (r *historyRing) between(from, to time.Time) []HistorySample {
+	samples := []HistorySample{}
+	for i := sort.Search(r.count, func(i int) bool { return !r.at(i).time.Before(from) }); i < r.count; i++ {
+		e := r.at(i)
+		if e.time.After(to) {
+			break
+		}
+		s := HistorySample{Time: e.time, Categories: make(map[string]HistoryValues)}
+		for c, v := range e.values {
+			if v != (HistoryValues{}) {
+				s.Categories[historyCategories[c]] = v
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// History keeps the memory of each process by mapping category at every poll
+// for the retention period, including processes that have exited since.
+type History struct {
+	mu        sync.Mutex
+	retention time.Duration
+	capacity  int
+	targets   map[Target]*historyRing
+}
+
+// NewHistory creates a history holding the samples of the retention period
+// taken at the given polling interval.
+func NewHistory(retention, interval time.Duration) *History {
+	return &History{
+		retention: retention,
+		capacity:  int(retention/interval) + 1,
+		targets:   make(map[Target]*historyRing),
+	}
+}
+
+// Add records a sample of each snapshot and forgets the processes that have
+// not been seen for longer than the retention period.
+func (h *History) Add(snapshots []*ProcessSnapshot, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range h.targets {
+		r.running = false
+	}
+	for _, ps := range snapshots {
+		e := historyEntry{time: now}
+		for _, m := range ps.Mappings {
+			c := slices.Index(historyCategories[:], MappingCategory(m.Path))
+			e.values[c].RssBytes += m.RssBytes
+			e.values[c].PssBytes += m.PssBytes
+			e.values[c].UssBytes += m.PrivateCleanBytes + m.PrivateDirtyBytes
+			e.values[c].SwapBytes += m.SwapBytes
+		}
+		r, found := h.targets[ps.Target]
+		if !found {
+			r = &historyRing{}
+			h.targets[ps.Target] = r
+		}
+		r.comm = ps.Comm
+		r.running = true
+		r.push(e, h.capacity)
+	}
+
+	cutoff := now.Add(-h.retention)
+	for t, r := range h.targets {
+		r.dropBefore(cutoff)
+		if r.count == 0 {
+			delete(h.targets, t)
+		}
+	}
+}
+
+// Query returns the samples of the processes matching the filter taken between
+// from and to. The pid selects a single process when not 0.
+func (h *History) Query(filter ProcessFilter, pid int, from, to time.Time) *HistoryReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := &HistoryReport{From: from, To: to, Targets: []*TargetHistory{}}
+	for t, r := range h.targets {
+		if !filter.Matches(t, r.comm) || (pid != 0 && t.PID != pid) {
+			continue
+		}
+		samples := r.between(from, to)
+		if len(samples) == 0 {
+			continue
+		}
+		report.Targets = append(report.Targets, &TargetHistory{Target: t, Comm: r.comm, Running: r.running, Samples: samples})
+	}
+	slices.SortFunc(report.Targets, func(a, b *TargetHistory) int {
+		return cmp.Or(
+			strings.Compare(a.Namespace, b.Namespace),
+			strings.Compare(a.Pod, b.Pod),
+			strings.Compare(a.Container, b.Container),
+			cmp.Compare(a.PID, b.PID))
+	})
+	return report
+}
+
+// parseHistoryTime parses a query time given in RFC 3339 format or as Unix seconds.
+func parseHistoryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or Unix seconds", v)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// historyHandler serves /api/v1/history. The target query parameter selects
+// processes in the format namespace/pod/container/command, where trailing
+// segments can be left out and * matches any value, and the optional pid
+// parameter selects a single process. The from and to parameters limit the
+// time range, by default the whole retention period.
+func historyHandler(history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		parts := strings.SplitN(query.Get("target"), "/", 4)
+		for len(parts) < 4 {
+			parts = append(parts, "*")
+		}
+		filter := ProcessFilter{Namespace: parts[0], Pod: parts[1], Container: parts[2], Command: parts[3]}
+		for _, p := range []*string{&filter.Namespace, &filter.Pod, &filter.Container, &filter.Command} {
+			if *p == "" {
+				*p = "*"
+			}
+		}
+
+		var pid int
+		if v := query.Get("pid"); v != "" {
+			var err error
+			if pid, err = strconv.Atoi(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid pid %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		to := time.Now()
+		var err error
+		if v := query.Get("to"); v != "" {
+			if to, err = parseHistoryTime(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.Add(-history.retention)
+		if v := query.Get("from"); v != "" {
+			if from, err = parseHistoryTime(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if from.After(to) {
+			http.Error(w, "from is after to", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(history.Query(filter, pid, from, to)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryRecordsExitedProcesses(t *testing.T) {
+	target := Target{Namespace: "default", Pod: "app-0", Container: "app", PID: 42}
+	all := ProcessFilter{Namespace: "*", Pod: "*", Container: "*", Command: "*"}
+	snapshot := &ProcessSnapshot{
+		Target:   target,
+		Comm:     "java",
+		Mappings: []*SmapsMapping{{Path: "[heap]", RssBytes: 4096, PssBytes: 4096, PrivateDirtyBytes: 4096}},
+	}
+
+	h := NewHistory(time.Minute, 10*time.Second)
+	start := time.Unix(1000, 0)
+	h.Add([]*ProcessSnapshot{snapshot}, start)
+
+	// The last matching process is gone, e.g. OOM-killed.
+	h.Add(nil, start.Add(10*time.Second))
+
+	report := h.Query(all, 0, start, start.Add(10*time.Second))
+	if len(report.Targets) != 1 {
+		t.Fatalf("got %d targets, want 1", len(report.Targets))
+	}
+	got := report.Targets[0]
+	if got.Running {
+		t.Error("exited process is reported as running")
+	}
+	if len(got.Samples) != 1 || got.Samples[0].Categories[CategoryHeap].RssBytes != 4096 {
+		t.Errorf("unexpected samples %+v", got.Samples)
+	}
+
+	// Polls without targets still expire the entries older than the retention period.
+	h.Add(nil, start.Add(2*time.Minute))
+	if report := h.Query(all, 0, start, start.Add(2*time.Minute)); len(report.Targets) != 0 {
+		t.Errorf("got %d targets after retention period, want 0", len(report.Targets))
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Output formats of the inspect command.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputCSV  = "csv"
+	OutputYAML = "yaml"
+)
+
+// ContainerReport holds the processes of a container and their mappings aggregated by path.
+type ContainerReport struct {
+	Namespace string           `json:"namespace"`
+	Pod       string           `json:"pod"`
+	Container string           `json:"container"`
+	Totals    MemoryTotals     `json:"totals"`
+	Mappings  []*SmapsMapping  `json:"mappings"`
+	Processes []*ProcessReport `json:"processes"`
+}
+
+// InspectReport is the result of the inspect command.
+type InspectReport struct {
+	Time       time.Time          `json:"time"`
+	Containers []*ContainerReport `json:"containers"`
+}
+
+// BuildInspectReport groups the snapshots by container. The mappings of each
+// process and the mappings of each container, aggregated by path over its
+// processes, are selected with the query.
+func BuildInspectReport(snapshots []*ProcessSnapshot, q MappingQuery, now time.Time) *InspectReport {
+	processes := BuildTargetsReport(snapshots, q, now).Processes
+	report := &InspectReport{Time: now, Containers: []*ContainerReport{}}
+	containers := groupByContainer(snapshots)
+	refs := slices.Collect(maps.Keys(containers))
+	sortContainerRefs(refs)
+	for _, ref := range refs {
+		var vmas []*SmapsMapping
+		for _, ps := range containers[ref] {
+			vmas = append(vmas, ps.VMAs...)
+		}
+		cr := &ContainerReport{
+			Namespace: ref.Namespace,
+			Pod:       ref.Pod,
+			Container: ref.Container,
+			Totals:    SumMemoryTotals(containers[ref]),
+			Mappings:  q.Select(AggregateSmaps(vmas)),
+		}
+		for _, pr := range processes {
+			if pr.Namespace == ref.Namespace && pr.Pod == ref.Pod && pr.Container == ref.Container {
+				cr.Processes = append(cr.Processes, pr)
+			}
+		}
+		report.Containers = append(report.Containers, cr)
+	}
+	return report
+}
+
+// inspectCommand implements the inspect subcommand, which prints the memory
+// breakdown of the selected processes once and exits.
+func inspectCommand(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	output := fs.String("o", OutputText, "Output format: text, json, csv or yaml")
+	aggregate := fs.Bool("aggregate", false, "Merge the VMAs of each path within a process")
+	sortBy := fs.String("sort", "rss", "Field to sort mappings by in descending order, empty to keep the address order")
+	limit := fs.Int("limit", 0, "Maximum number of mappings printed per process and container, all when 0")
+	category := fs.String("category", "", "Comma separated mapping categories to print, all when empty")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] inspect [inspect flags] [namespace/pod/container/command]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Prints the memory of the selected processes, by default those selected by -filter.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	selector := *processFilter
+	if fs.NArg() == 1 {
+		selector = fs.Arg(0)
+	}
+	filter, err := ParseProcessFilter(selector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid selector, expected namespace/pod/container/command:", err)
+		return 2
+	}
+	q, err := ParseMappingQuery(map[string][]string{
+		"aggregate": {strconv.FormatBool(*aggregate)},
+		"sort":      {*sortBy},
+		"limit":     {strconv.Itoa(*limit)},
+		"category":  {*category},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	finder, err := newFinder()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := collectMatchingProcesses(finder, filter, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get targets:", err)
+		return 1
+	}
+	report := BuildInspectReport(snapshots, q, time.Now())
+	if err := writeInspectReport(os.Stdout, report, *output, *aggregate); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeInspectReport prints the report in the format. Aggregated tells whether the
+// mappings of the processes were aggregated by path.
+func writeInspectReport(w io.Writer, report *InspectReport, format string, aggregated bool) error {
+	switch format {
+	case OutputText:
+		return writeInspectText(w, report, aggregated)
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case OutputCSV:
+		return writeInspectCSV(w, report)
+	case OutputYAML:
+		// Convert through JSON so that YAML uses the same field names and order.
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		data, err = yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
+
+// inspectColumns lists the values printed per mapping, in KiB like pmap -X.
+var inspectColumns = []struct {
+	name  string
+	value func(m *SmapsMapping) int64
+}{
+	{"Size", func(m *SmapsMapping) int64 { return m.SizeBytes }},
+	{"Rss", func(m *SmapsMapping) int64 { return m.RssBytes }},
+	{"Pss", func(m *SmapsMapping) int64 { return m.PssBytes }},
+	{"Shared_Clean", func(m *SmapsMapping) int64 { return m.SharedCleanBytes }},
+	{"Shared_Dirty", func(m *SmapsMapping) int64 { return m.SharedDirtyBytes }},
+	{"Private_Clean", func(m *SmapsMapping) int64 { return m.PrivateCleanBytes }},
+	{"Private_Dirty", func(m *SmapsMapping) int64 { return m.PrivateDirtyBytes }},
+	{"Referenced", func(m *SmapsMapping) int64 { return m.ReferencedBytes }},
+	{"Anonymous", func(m *SmapsMapping) int64 { return m.AnonymousBytes }},
+	{"Swap", func(m *SmapsMapping) int64 { return m.SwapBytes }},
+	{"Locked", func(m *SmapsMapping) int64 { return m.LockedBytes }},
+}
+
+func writeInspectText(w io.Writer, report *InspectReport, aggregated bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	for _, cr := range report.Containers {
+		fmt.Fprintf(tw, "Container %s/%s/%s: Rss %d kB, Pss %d kB, Uss %d kB, Swap %d kB\n",
+			cr.Namespace, cr.Pod, cr.Container, cr.Totals.RssBytes/1024, cr.Totals.PssBytes/1024, cr.Totals.UssBytes/1024, cr.Totals.SwapBytes/1024)
+		for _, pr := range cr.Processes {
+			command := pr.Comm
+			if len(pr.Cmdline) > 0 {
+				command = strings.Join(pr.Cmdline, " ")
+			}
+			fmt.Fprintf(tw, "\n%d: %s\n", pr.PID, command)
+			writeMappingTable(tw, pr.Mappings, aggregated)
+		}
+		fmt.Fprintf(tw, "\nMappings of all processes in %s/%s/%s\n", cr.Namespace, cr.Pod, cr.Container)
+		writeMappingTable(tw, cr.Mappings, true)
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// writeMappingTable prints the mappings with a total row. Aggregated mappings have
+// their VMA count in place of the address, permissions, offset, device and inode.
+func writeMappingTable(w io.Writer, mappings []*SmapsMapping, aggregated bool) {
+	header := []string{"Address", "Perm", "Offset", "Device", "Inode"}
+	if aggregated {
+		header = []string{"VMAs"}
+	}
+	for _, c := range inspectColumns {
+		header = append(header, c.name)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t Mapping")
+
+	totals := make([]int64, len(inspectColumns))
+	for _, m := range mappings {
+		row := []string{strconv.Itoa(m.VMACount)}
+		if !aggregated {
+			start, _, _ := strings.Cut(m.AddrRange, "-")
+			row = []string{start, m.Perms, m.Offset, m.Dev, m.Inode}
+		}
+		for i, c := range inspectColumns {
+			row = append(row, strconv.FormatInt(c.value(m)/1024, 10))
+			totals[i] += c.value(m)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t "+m.Path)
+	}
+
+	row := slices.Repeat([]string{""}, len(header)-len(inspectColumns))
+	row[0] = "Total"
+	for _, t := range totals {
+		row = append(row, strconv.FormatInt(t/1024, 10))
+	}
+	fmt.Fprintln(w, strings.Join(row, "\t")+"\t KB")
+}
+
+// writeInspectCSV prints one row per mapping of each process, with level "process",
+// followed by the mappings of all processes of the container aggregated by path,
+// with level "container". Container rows have no pid, comm or VMA address.
+func writeInspectCSV(w io.Writer, report *InspectReport) error {
+	cw := csv.NewWriter(w)
+	header := []string{"level", "namespace", "pod", "container", "pid", "comm", "address", "perms", "offset", "dev", "inode", "path", "category", "vma_count"}
+	for _, c := range inspectColumns {
+		header = append(header, strings.ToLower(c.name)+"_bytes")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	writeRow := func(prefix []string, m *SmapsMapping) error {
+		row := append(prefix, m.Dev, m.Inode, m.Path, MappingCategory(m.Path), strconv.Itoa(m.VMACount))
+		for _, c := range inspectColumns {
+			row = append(row, strconv.FormatInt(c.value(m), 10))
+		}
+		return cw.Write(row)
+	}
+	for _, cr := range report.Containers {
+		for _, pr := range cr.Processes {
+			for _, m := range pr.Mappings {
+				prefix := []string{"process", cr.Namespace, cr.Pod, cr.Container, strconv.Itoa(pr.PID), pr.Comm, m.AddrRange, m.Perms, m.Offset}
+				if err := writeRow(prefix, m); err != nil {
+					return err
+				}
+			}
+		}
+		for _, m := range cr.Mappings {
+			prefix := []string{"container", cr.Namespace, cr.Pod, cr.Container, "", "", "", "", ""}
+			if err := writeRow(prefix, m); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
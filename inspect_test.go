@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestWriteInspectCSVIncludesContainerRows(t *testing.T) {
+	libc := &SmapsMapping{AddrRange: "7f1c2e9f5000-7f1c2ea00000", Perms: "r-xp", Path: "/usr/lib/libc.so.6", VMACount: 1, RssBytes: 8 << 10}
+	report := &InspectReport{
+		Time: time.Unix(1000, 0),
+		Containers: []*ContainerReport{{
+			Namespace: "default",
+			Pod:       "app-0",
+			Container: "app",
+			Mappings:  []*SmapsMapping{{Path: "/usr/lib/libc.so.6", VMACount: 2, RssBytes: 16 << 10}},
+			Processes: []*ProcessReport{{Namespace: "default", Pod: "app-0", Container: "app", PID: 42, Comm: "app", Mappings: []*SmapsMapping{libc}}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := writeInspectCSV(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want a header, a process row and a container row", len(records))
+	}
+	column := make(map[string]int)
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	for _, tc := range []struct {
+		record                []string
+		level, pid, vmas, rss string
+	}{
+		{records[1], "process", "42", "1", "8192"},
+		{records[2], "container", "", "2", "16384"},
+	} {
+		if tc.record[column["level"]] != tc.level || tc.record[column["pid"]] != tc.pid ||
+			tc.record[column["vma_count"]] != tc.vmas || tc.record[column["rss_bytes"]] != tc.rss {
+			t.Errorf("got row %v, want level %s, pid %q, %s VMAs and Rss %s", tc.record, tc.level, tc.pid, tc.vmas, tc.rss)
+		}
+	}
+}
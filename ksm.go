@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// KSMStat holds the kernel samepage merging counters of a process from
+// /proc/[pid]/ksm_stat and /proc/[pid]/ksm_merging_pages.
+type KSMStat struct {
+	RmapItems    int64
+	ZeroPages    int64
+	MergingPages int64
+	ProfitBytes  int64
+	MergeAny     bool
+	Mergeable    bool
+}
+
+// ParseKSMStat parses the contents of a /proc/[pid]/ksm_stat file.
+func ParseKSMStat(r io.Reader) (*KSMStat, error) {
+	stat := &KSMStat{}
+
+	// ksm_rmap_items 1024
+	// ksm_merge_any: no
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		key := strings.TrimSuffix(fields[0], ":")
+		value := fields[1]
+		switch key {
+		case "ksm_merge_any":
+			stat.MergeAny = value == "yes"
+			continue
+		case "ksm_mergeable":
+			stat.Mergeable = value == "yes"
+			continue
+		}
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "ksm_rmap_items":
+			stat.RmapItems = val
+		case "ksm_zero_pages":
+			stat.ZeroPages = val
+		case "ksm_merging_pages":
+			stat.MergingPages = val
+		case "ksm_process_profit":
+			stat.ProfitBytes = val
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return stat, nil
+}
+
+// MergeableBytes returns the resident bytes of the mappings marked with madvise(MADV_MERGEABLE).
+func MergeableBytes(mappings []*SmapsMapping) int64 {
+	var mergeable int64
+	for _, m := range mappings {
+		if m.Mergeable() {
+			mergeable += m.RssBytes
+		}
+	}
+	return mergeable
+}
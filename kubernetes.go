@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/namespaces"
@@ -20,6 +21,12 @@ type KubernetesFinder struct {
 	criClient        runtimeapi.RuntimeServiceClient
 	containerdClient *containerd.Client
 	procPath         string
+
+	// podAnnotations caches the annotations of the pods listed by the last GetTargets call, keyed by pod UID.
+	// It holds all pods, not only the matching ones, so that a call with a narrower filter does not drop
+	// the pods of another.
+	podAnnotationsMu sync.Mutex
+	podAnnotations   map[string]map[string]string
 }
 
 func NewKubernetesPIDFinder(
@@ -48,9 +55,9 @@ func NewKubernetesPIDFinder(
 	}, nil
 }
 
-// GetHostPIDs returns a list of host PIDs for processes matching the given namespace, pod, container, and command (comm).
-func (k *KubernetesFinder) GetHostPIDs(namespace, pod, container, comm string) ([]int, error) {
-	slog.Debug("Getting host PIDs", "namespace", namespace, "pod", pod, "container", container, "comm", comm)
+// GetTargets returns the processes matching the given filter.
+func (k *KubernetesFinder) GetTargets(filter ProcessFilter) ([]Target, error) {
+	slog.Debug("Getting targets", "filter", filter)
 
 	ctx := context.Background()
 
@@ -62,22 +69,31 @@ func (k *KubernetesFinder) GetHostPIDs(namespace, pod, container, comm string) (
 	}
 
 	// Filter sandboxes by namespace and pod name.
-	var podUIDs []string
+	var pods []*runtimeapi.PodSandbox
 	for _, sb := range podResp.Items {
-		if (namespace == "*" || sb.Labels["io.kubernetes.pod.namespace"] == namespace) &&
-			(pod == "*" || sb.Labels["io.kubernetes.pod.name"] == pod) {
-			podUIDs = append(podUIDs, sb.Labels["io.kubernetes.pod.uid"])
+		if (filter.Namespace == "*" || sb.Labels["io.kubernetes.pod.namespace"] == filter.Namespace) &&
+			(filter.Pod == "*" || sb.Labels["io.kubernetes.pod.name"] == filter.Pod) {
+			pods = append(pods, sb)
 		}
 	}
-	slog.Debug("Matching pod sandboxes", "num", len(podUIDs))
-	if len(podUIDs) == 0 {
-		return nil, fmt.Errorf("pod not found in sandboxes (namespace=%s, pod=%s)", namespace, pod)
+	slog.Debug("Matching pod sandboxes", "num", len(pods))
+
+	annotations := make(map[string]map[string]string, len(podResp.Items))
+	for _, sb := range podResp.Items {
+		annotations[sb.Labels["io.kubernetes.pod.uid"]] = sb.Annotations
+	}
+	k.podAnnotationsMu.Lock()
+	k.podAnnotations = annotations
+	k.podAnnotationsMu.Unlock()
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("%w: pod not found in sandboxes (namespace=%s, pod=%s)", ErrNoTargets, filter.Namespace, filter.Pod)
 	}
 
 	// Filter containers of each matching pod by container name.
-	var containerUIDs []string
-	for _, podUID := range podUIDs {
-		containers, err := k.getContainersForPod(ctx, podUID, container)
+	var containerTargets []Target
+	for _, sb := range pods {
+		podUID := sb.Labels["io.kubernetes.pod.uid"]
+		containers, err := k.getContainersForPod(ctx, podUID, filter.Container)
 		if err != nil {
 			continue
 		}
@@ -85,20 +101,26 @@ func (k *KubernetesFinder) GetHostPIDs(namespace, pod, container, comm string) (
 			if c.State != runtimeapi.ContainerState_CONTAINER_RUNNING {
 				continue
 			}
-			containerUIDs = append(containerUIDs, c.Id)
+			containerTargets = append(containerTargets, Target{
+				Namespace:   sb.Labels["io.kubernetes.pod.namespace"],
+				Pod:         sb.Labels["io.kubernetes.pod.name"],
+				PodUID:      podUID,
+				Container:   c.Labels["io.kubernetes.container.name"],
+				ContainerID: c.Id,
+			})
 		}
 	}
-	slog.Debug("Matching container sandboxes", "num", len(containerUIDs))
-	if len(containerUIDs) == 0 {
-		return nil, fmt.Errorf("no running containers found in the specified pod(s) (namespace=%s, pod=%s, container=%s)", namespace, pod, container)
+	slog.Debug("Matching container sandboxes", "num", len(containerTargets))
+	if len(containerTargets) == 0 {
+		return nil, fmt.Errorf("%w: no running containers found in the specified pod(s) (namespace=%s, pod=%s, container=%s)", ErrNoTargets, filter.Namespace, filter.Pod, filter.Container)
 	}
 
 	// For each container sandbox, get init PID and find all PIDs in the same PID namespace.
-	var allPIDs []int
-	for _, containerUID := range containerUIDs {
-		initPID, err := k.getInitPIDFromContainerd(containerUID)
+	var targets []Target
+	for _, ct := range containerTargets {
+		initPID, err := k.getInitPIDFromContainerd(ct.ContainerID)
 		if err != nil {
-			slog.Error("Failed to get init PID from containerd", "containerID", containerUID, "error", err)
+			slog.Error("Failed to get init PID from containerd", "containerID", ct.ContainerID, "error", err)
 			continue
 		}
 
@@ -108,14 +130,24 @@ func (k *KubernetesFinder) GetHostPIDs(namespace, pod, container, comm string) (
 			continue
 		}
 
-		pids := k.findPIDsInPIDNamespace(pidNS, comm)
-		allPIDs = append(allPIDs, pids...)
+		for _, pid := range k.findPIDsInPIDNamespace(pidNS, filter.Command) {
+			t := ct
+			t.PID = pid
+			targets = append(targets, t)
+		}
 	}
-	slog.Debug("Matching PIDs in containers", "num", len(allPIDs), "pids", allPIDs)
-	if len(allPIDs) == 0 {
-		return nil, fmt.Errorf("no PIDs found in the specified container(s) (namespace=%s, pod=%s, container=%s, comm=%s)", namespace, pod, container, comm)
+	slog.Debug("Matching PIDs in containers", "num", len(targets))
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: no PIDs found in the specified container(s) (namespace=%s, pod=%s, container=%s, comm=%s)", ErrNoTargets, filter.Namespace, filter.Pod, filter.Container, filter.Command)
 	}
-	return allPIDs, nil
+	return targets, nil
+}
+
+// PodAnnotations returns the annotations of a pod listed by the last GetTargets call.
+func (k *KubernetesFinder) PodAnnotations(podUID string) map[string]string {
+	k.podAnnotationsMu.Lock()
+	defer k.podAnnotationsMu.Unlock()
+	return k.podAnnotations[podUID]
 }
 
 // getContainersForPod lists containers for a given pod UID and optional container name using CRI API.
@@ -217,3 +249,31 @@ func (k *KubernetesFinder) commMatches(pid, comm string) bool {
 	procComm := strings.TrimSpace(string(data))
 	return procComm == comm
 }
+
+// ContainerResources holds the memory resources the runtime reports for a container.
+// Zero means not specified.
+type ContainerResources struct {
+	MemoryLimitBytes   int64 `json:"memory_limit_bytes"`
+	MemoryRequestBytes int64 `json:"memory_request_bytes"`
+}
+
+// GetContainerResources returns the memory resources of a container using CRI ContainerStatus.
+// The memory request is only known when the kubelet sets memory.min, i.e. when the MemoryQoS feature is enabled.
+func (k *KubernetesFinder) GetContainerResources(containerID string) (*ContainerResources, error) {
+	resp, err := k.criClient.ContainerStatus(context.Background(), &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+	if err != nil {
+		return nil, err
+	}
+	res := &ContainerResources{}
+	if resp.Status == nil || resp.Status.Resources == nil || resp.Status.Resources.Linux == nil {
+		return res, nil
+	}
+	linux := resp.Status.Resources.Linux
+	res.MemoryLimitBytes = linux.MemoryLimitInBytes
+	if minimum, found := linux.Unified["memory.min"]; found {
+		if request, err := strconv.ParseInt(minimum, 10, 64); err == nil {
+			res.MemoryRequestBytes = request
+		}
+	}
+	return res, nil
+}
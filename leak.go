@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// leakSamplesPerWindow is the number of samples kept per mapping and value over the leak detection window.
+const leakSamplesPerWindow = 60
+
+// Values of a mapping whose growth is tracked by LeakDetector.
+const (
+	LeakValueRss  = "rss"
+	LeakValuePss  = "pss"
+	LeakValueAnon = "anon"
+)
+
+var leakValues = []string{LeakValueRss, LeakValuePss, LeakValueAnon}
+
+// MappingGrowth holds the growth trend of a mapping.
+type MappingGrowth struct {
+	// Rates holds the growth rate in bytes per second by tracked value.
+	Rates map[string]float64
+	// LeakScore is between 0 and 1, higher when the mapping grows steadily over the whole window.
+	LeakScore float64
+}
+
+// MappingRef identifies a mapping of a process by path.
+type MappingRef struct {
+	Target Target
+	Path   string
+}
+
+// LeakDetector keeps a rolling history of the size of each mapping and fits a growth trend to it.
+//
+// The leak score of a mapping is the coefficient of determination of the
+// linear fit, multiplied by the fraction of samples that did not decrease and
+// by the fraction of the window the history covers. A mapping that grows
+// monotonically by at least threshold bytes over the whole window is logged.
+type LeakDetector struct {
+	window    time.Duration
+	threshold int64
+
+	lastSample time.Time
+	growth     map[string]*growthTracker[MappingRef]
+	reported   map[MappingRef]time.Time
+}
+
+// NewLeakDetector creates a detector that fits the growth of mappings over window.
+func NewLeakDetector(window time.Duration, threshold int64) *LeakDetector {
+	growth := make(map[string]*growthTracker[MappingRef])
+	for _, value := range leakValues {
+		growth[value] = newGrowthTracker[MappingRef](window)
+	}
+	return &LeakDetector{
+		window:    window,
+		threshold: threshold,
+		growth:    growth,
+		reported:  make(map[MappingRef]time.Time),
+	}
+}
+
+// Update records the mappings of the snapshots, sets their Growth and returns
+// the mappings that have disappeared since the previous update.
+func (d *LeakDetector) Update(snapshots []*ProcessSnapshot, now time.Time) []MappingRef {
+	sample := now.Sub(d.lastSample) >= d.window/leakSamplesPerWindow
+	if sample {
+		d.lastSample = now
+	}
+
+	current := make(map[MappingRef]bool)
+	for _, ps := range snapshots {
+		ps.Growth = make(map[string]*MappingGrowth)
+		for _, m := range ps.Mappings {
+			ref := MappingRef{Target: ps.Target, Path: m.Path}
+			current[ref] = true
+			if sample {
+				d.growth[LeakValueRss].Add(ref, now, float64(m.RssBytes))
+				d.growth[LeakValuePss].Add(ref, now, float64(m.PssBytes))
+				d.growth[LeakValueAnon].Add(ref, now, float64(m.AnonymousBytes))
+			}
+			if g := d.analyze(ref, ps.Comm, now); g != nil {
+				ps.Growth[m.Path] = g
+			}
+		}
+	}
+
+	var removed []MappingRef
+	for ref := range d.growth[LeakValueRss].samples {
+		if !current[ref] {
+			removed = append(removed, ref)
+			for _, g := range d.growth {
+				g.Forget(ref)
+			}
+			delete(d.reported, ref)
+		}
+	}
+	return removed
+}
+
+// analyze fits the growth trend of the mapping and logs it when it looks like a leak.
+// It returns nil until enough samples have been recorded.
+func (d *LeakDetector) analyze(ref MappingRef, comm string, now time.Time) *MappingGrowth {
+	g := &MappingGrowth{Rates: make(map[string]float64)}
+	for _, value := range leakValues {
+		samples := d.growth[value].Samples(ref)
+		if len(samples) < 2 {
+			return nil
+		}
+		slope, r2 := linearRegression(samples)
+		g.Rates[value] = slope
+		if slope > 0 {
+			coverage := min(1, samples[len(samples)-1].time.Sub(samples[0].time).Seconds()/d.window.Seconds())
+			g.LeakScore = max(g.LeakScore, r2*nonDecreasingFraction(samples)*coverage)
+		}
+	}
+
+	samples := d.growth[LeakValueRss].Samples(ref)
+	first, last := samples[0], samples[len(samples)-1]
+	span := last.time.Sub(first.time)
+	grown := last.value - first.value
+	if span >= d.window*9/10 && nonDecreasingFraction(samples) == 1 && grown >= float64(d.threshold) {
+		if reported, ok := d.reported[ref]; !ok || now.Sub(reported) >= d.window {
+			slog.Warn("Mapping is growing monotonically",
+				"namespace", ref.Target.Namespace, "pod", ref.Target.Pod, "container", ref.Target.Container,
+				"pid", ref.Target.PID, "comm", comm, "path", ref.Path,
+				"grownBytes", int64(grown), "rssBytes", int64(last.value), "window", span.Round(time.Second),
+				"bytesPerSecond", g.Rates[LeakValueRss], "leakScore", g.LeakScore)
+			d.reported[ref] = now
+		}
+	}
+	return g
+}
+
+// nonDecreasingFraction returns the fraction of consecutive samples whose value did not decrease.
+func nonDecreasingFraction(samples []growthSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	steps := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i].value >= samples[i-1].value {
+			steps++
+		}
+	}
+	return float64(steps) / float64(len(samples)-1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// MemoryLimits holds the effective memory limits of a container in bytes.
+// Zero means not set.
+type MemoryLimits struct {
+	LimitBytes   int64
+	RequestBytes int64
+	HighBytes    int64
+}
+
+// ResolveMemoryLimits combines the resources reported by the runtime with the cgroup limits.
+// The runtime is preferred, the cgroup is used when the runtime does not report a value.
+// Either argument may be nil.
+func ResolveMemoryLimits(res *ContainerResources, cg *CgroupMemory) MemoryLimits {
+	var l MemoryLimits
+	if res != nil {
+		l.LimitBytes = res.MemoryLimitBytes
+		l.RequestBytes = res.MemoryRequestBytes
+	}
+	if cg != nil {
+		if l.LimitBytes == 0 && cg.MaxBytes != math.MaxInt64 {
+			l.LimitBytes = cg.MaxBytes
+		}
+		if l.RequestBytes == 0 {
+			l.RequestBytes = cg.MinBytes
+		}
+		if cg.HighBytes != math.MaxInt64 {
+			l.HighBytes = cg.HighBytes
+		}
+	}
+	return l
+}
+
+// UnreclaimableBytes returns the memory of the cgroup that cannot be reclaimed
+// without swap: everything except the file-backed page cache. Shmem is
+// accounted as file memory but cannot be dropped, so it is counted as unreclaimable.
+func UnreclaimableBytes(cg *CgroupMemory) int64 {
+	return max(0, cg.CurrentBytes-(cg.Stat["file"]-cg.Stat["shmem"]))
+}
+
+// growthSample is a single observation of a growing value.
+type growthSample struct {
+	time  time.Time
+	value float64
+}
+
+// growthTracker keeps a sliding window of samples per key and estimates their growth rate.
+type growthTracker[K comparable] struct {
+	window  time.Duration
+	samples map[K][]growthSample
+}
+
+func newGrowthTracker[K comparable](window time.Duration) *growthTracker[K] {
+	return &growthTracker[K]{
+		window:  window,
+		samples: make(map[K][]growthSample),
+	}
+}
+
+// Add records a sample and drops the samples that have fallen out of the window.
+func (g *growthTracker[K]) Add(key K, now time.Time, value float64) {
+	samples := append(g.samples[key], growthSample{time: now, value: value})
+	cutoff := now.Add(-g.window)
+	for len(samples) > 0 && samples[0].time.Before(cutoff) {
+		samples = samples[1:]
+	}
+	g.samples[key] = samples
+}
+
+// Rate returns the growth rate per second over the window, using least squares linear regression.
+// It returns false until at least two samples have been recorded.
+func (g *growthTracker[K]) Rate(key K) (float64, bool) {
+	samples := g.samples[key]
+	if len(samples) < 2 {
+		return 0, false
+	}
+	slope, _ := linearRegression(samples)
+	return slope, true
+}
+
+// Samples returns the samples currently in the window.
+func (g *growthTracker[K]) Samples(key K) []growthSample {
+	return g.samples[key]
+}
+
+// Forget removes all samples of the key.
+func (g *growthTracker[K]) Forget(key K) {
+	delete(g.samples, key)
+}
+
+// linearRegression fits value = slope * t + intercept by least squares, where t is
+// seconds since the first sample. It returns the slope and the coefficient of determination R².
+func linearRegression(samples []growthSample) (float64, float64) {
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX, sumYY float64
+	for _, s := range samples {
+		x := s.time.Sub(samples[0].time).Seconds()
+		sumX += x
+		sumY += s.value
+		sumXY += x * s.value
+		sumXX += x * x
+		sumYY += s.value * s.value
+	}
+	varX := n*sumXX - sumX*sumX
+	if varX == 0 {
+		return 0, 0
+	}
+	cov := n*sumXY - sumX*sumY
+	slope := cov / varX
+	varY := n*sumYY - sumY*sumY
+	if varY == 0 {
+		return slope, 1
+	}
+	return slope, (cov * cov) / (varX * varY)
+}
+
+// timeToLimit projects the seconds until usage reaches the limit at the given growth rate.
+// It returns +Inf when usage is not growing.
+func timeToLimit(headroom int64, rate float64) float64 {
+	if rate <= 0 {
+		return math.Inf(1)
+	}
+	return float64(headroom) / rate
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -19,59 +27,207 @@ var (
 	interval             = flag.Duration("scrape-interval", 1*time.Second, "Scrape interval for metrics")
 	logLevel             = flag.String("log-level", "info", "Log level: debug, info, warn, error, none")
 	containerdSocketPath = flag.String("containerd-sock", "/run/containerd/containerd.sock", "Path to containerd socket")
+	cgroupPath           = flag.String("cgroup-path", "/sys/fs/cgroup", "Path where the cgroup v2 hierarchy is mounted")
+	growthWindow         = flag.Duration("growth-window", 5*time.Minute, "Time window used to estimate memory growth rates")
+	numaMaps             = flag.Bool("numa-maps", false, "Export NUMA placement of mappings from /proc/[pid]/numa_maps")
+	pagemapPaths         = flag.String("pagemap-paths", "", "Regular expression of mapping paths to analyze page by page through pagemap and kpageflags. Disabled when empty.")
+	pagemapMaxPages      = flag.Int64("pagemap-max-pages", 262144, "Maximum number of pages analyzed through pagemap per scrape interval")
+	sysPath              = flag.String("sys-path", "/sys", "Path where sysfs is mounted")
+	idlePaths            = flag.String("idle-paths", "", "Regular expression of mapping paths to estimate the working set of using idle page tracking. Disabled when empty.")
+	idleInterval         = flag.Duration("idle-interval", 30*time.Second, "Interval between idle page tracking cycles")
+	idleColdCycles       = flag.Int("idle-cold-cycles", 4, "Number of idle page tracking cycles without access after which a page is considered cold")
+	idleMaxPages         = flag.Int64("idle-max-pages", 262144, "Maximum number of pages checked per idle page tracking cycle")
+	idlePagesPerSecond   = flag.Float64("idle-pages-per-second", 100000, "Maximum number of pages checked per second by idle page tracking")
+	sharedPFN            = flag.Bool("shared-pfn", false, "Compute memory shared between containers precisely from page frame numbers in pagemap, limited by -pagemap-max-pages")
+	softDirty            = flag.Bool("soft-dirty", false, "Measure the write rate of processes in pods annotated with "+SoftDirtyAnnotation+"=true by resetting their soft-dirty bits")
+	softDirtyInterval    = flag.Duration("soft-dirty-interval", 10*time.Second, "Interval between soft-dirty measurements")
+	softDirtyMaxPages    = flag.Int64("soft-dirty-max-pages", 1048576, "Maximum number of pages read per soft-dirty measurement")
+	dedup                = flag.Bool("dedup", false, "Sample pages of anonymous mappings through /proc/[pid]/mem to detect zero-filled and duplicate pages")
+	dedupInterval        = flag.Duration("dedup-interval", 5*time.Minute, "Interval between duplicate content analysis cycles")
+	dedupMaxPages        = flag.Int64("dedup-max-pages", 65536, "Maximum number of pages read per duplicate content analysis cycle, including pagemap")
+	dedupPagesPerSecond  = flag.Float64("dedup-pages-per-second", 10000, "Maximum number of pages read per second by the duplicate content analysis")
+	leakDetection        = flag.Bool("leak-detection", false, "Fit the growth trend of each mapping to find leaks")
+	leakWindow           = flag.Duration("leak-window", 30*time.Minute, "Time window used to fit the growth trend of mappings")
+	leakThreshold        = flag.Int64("leak-threshold", 64<<20, "Growth in bytes over the leak window after which a monotonically growing mapping is logged")
+	peakMetrics          = flag.String("peak-metrics", "", "Regular expression of metric names whose maximum, minimum and average between scrapes are exported. Disabled when empty.")
+	otlpEndpoint         = flag.String("otlp-endpoint", "", "OTLP endpoint to push metrics to: host:port for grpc, URL for http. Disabled when empty.")
+	otlpProtocol         = flag.String("otlp-protocol", OTLPProtocolGRPC, "OTLP transport protocol: grpc or http")
+	otlpInsecure         = flag.Bool("otlp-insecure", false, "Disable TLS for the OTLP grpc endpoint")
+	otlpInterval         = flag.Duration("otlp-interval", 30*time.Second, "Interval between OTLP exports")
+	otlpTimeout          = flag.Duration("otlp-timeout", 10*time.Second, "Timeout of an OTLP export")
+	remoteWriteURL       = flag.String("remote-write-url", "", "Prometheus remote write endpoint to push metrics to. Disabled when empty.")
+	remoteWriteInterval  = flag.Duration("remote-write-interval", 30*time.Second, "Interval between samples pushed with remote write")
+	remoteWriteLabels    = flag.String("remote-write-external-labels", "", "Labels added to pushed series in the format name=value,name=value")
+	remoteWriteQueueSize = flag.Int("remote-write-queue-size", 500000, "Maximum number of samples queued for remote write")
+	remoteWriteBatchSize = flag.Int("remote-write-batch-size", 5000, "Maximum number of samples per remote write request")
+	remoteWriteRetries   = flag.Int("remote-write-max-retries", 5, "Maximum number of retries of a failed remote write request")
+	remoteWriteTimeout   = flag.Duration("remote-write-timeout", 10*time.Second, "Timeout of a remote write request")
+	historyRetention     = flag.Duration("history-retention", 15*time.Minute, "Time the memory history of processes, including exited ones, is kept for /api/v1/history. Disabled when 0.")
+	replayPath           = flag.String("replay", "", "Recording written by the record command to serve metrics from in place of the node. Disabled when empty.")
+	replaySpeed          = flag.Float64("replay-speed", 1, "Speed at which the replay advances through the recorded time")
 	processFilter        = flag.String("filter", "default/*/*/*", "Process to monitor in the format namespace/pod/container/command. Use * as a wildcard.")
 )
 
-func pollMetrics(finder *KubernetesFinder, namespace, pod, container, command string) {
+func pollMetrics(finder Finder, filter ProcessFilter, pagemapFilter *regexp.Regexp, estimator *WorkingSetEstimator, dirtyTracker *SoftDirtyTracker, leaks *LeakDetector, peaks *PeakTracker, history *History) {
 	ticker := time.NewTicker(*interval)
 	defer ticker.Stop()
 
+	previousTargets := make(map[Target]bool)
+	previousContainers := make(map[ContainerRef]bool)
+	previousPods := make(map[PodRef]bool)
+	previousPairs := make(map[[2]ContainerRef]bool)
+	previousNumaPolicies := make(map[Target]map[numaPolicySeries]bool)
+	growth := newGrowthTracker[ContainerRef](*growthWindow)
+	// pagemapFirst is the process the page level analysis starts from in the next poll.
+	pagemapFirst := 0
 	for {
-		<-ticker.C
+		now := <-ticker.C
 
-		slog.Debug("Polling containerd for matching processes", "namespace", namespace, "pod", pod, "container", container, "command", command)
-		pids, err := finder.GetHostPIDs(namespace, pod, container, command)
+		maxMapCount, err := readMaxMapCount()
 		if err != nil {
-			slog.Error("Failed to get host PIDs", "error", err)
-			continue
+			slog.Warn("Failed to read vm.max_map_count", "error", err)
+		} else {
+			VMMaxMapCount.Set(float64(maxMapCount))
 		}
-		if len(pids) == 0 {
-			slog.Warn("No matching processes found", "namespace", namespace, "pod", pod, "container", container, "command", command)
+
+		slog.Debug("Polling containerd for matching processes", "filter", filter)
+		// Without targets the poll still runs, so that the series of the processes
+		// that have gone, e.g. when the last matching pod was OOM-killed, are removed.
+		// Other errors, e.g. a transient CRI failure, keep the state of the previous poll.
+		targets, err := finder.GetTargets(filter)
+		if errors.Is(err, ErrNoTargets) {
+			slog.Warn("No matching processes found", "filter", filter, "error", err)
+			targets = nil
+		} else if err != nil {
+			slog.Error("Failed to get targets", "error", err)
 			continue
 		}
 
-		for _, pid := range pids {
-			slog.Debug("Processing smaps for", "pid", "namespace", namespace, "pod", pod, "container", container)
-			err := collectAndSetMetrics(pid, namespace, pod, container)
+		// The annotations are captured with the targets of this poll, since the
+		// finder replaces its cache on every GetTargets call, e.g. from the API.
+		annotations := make(map[string]map[string]string)
+		for _, t := range targets {
+			annotations[t.PodUID] = finder.PodAnnotations(t.PodUID)
+		}
+
+		pruneCgroupDirCache(targets)
+		snapshots := collectProcesses(targets)
+		if pagemapFilter != nil {
+			pagemapFirst = scanPages(snapshots, NewPageScanner(*procPath, pagemapFilter, *pagemapMaxPages), pagemapFirst)
+		}
+		if estimator != nil {
+			estimator.Update(snapshots)
+		}
+		if dirtyTracker != nil {
+			dirtyTracker.Update(snapshots, annotations, now)
+		}
+		var removedMappings []MappingRef
+		if leaks != nil {
+			removedMappings = leaks.Update(snapshots, now)
+		}
+		containers := groupByContainer(snapshots)
+		pods := groupByPod(snapshots)
+
+		currentTargets := make(map[Target]bool)
+		currentNumaPolicies := make(map[Target]map[numaPolicySeries]bool)
+		for _, ps := range snapshots {
+			for _, m := range ps.Mappings {
+				setMetrics(ps, m)
+			}
+			setAddressSpaceMetrics(ps, maxMapCount)
+			setProcessMemoryMetrics(ps)
+			setProcessStatusMetrics(ps)
+			setKSMMetrics(ps)
+			currentNumaPolicies[ps.Target] = setNumaMetrics(ps, previousNumaPolicies[ps.Target])
+			setPagemapMetrics(ps)
+			setWorkingSetMetrics(ps)
+			setSoftDirtyMetrics(ps)
+			setGrowthMetrics(ps)
+			currentTargets[ps.Target] = true
+		}
+
+		currentContainers := make(map[ContainerRef]bool)
+		for ref, processes := range containers {
+			setContainerMetrics(ref, processes)
+			setContainerWorkingSetMetrics(ref, processes)
+			setContainerSoftDirtyMetrics(ref, processes)
+			setContainerKSMMetrics(ref, processes)
+			cg, err := readContainerCgroup(processes)
 			if err != nil {
-				slog.Error("Failed to collect/set metrics", "pid", "error", err)
+				slog.Debug("Failed to read container cgroup", "namespace", ref.Namespace, "pod", ref.Pod, "container", ref.Container, "error", err)
+			} else {
+				setContainerCgroupMetrics(ref, processes, cg)
 			}
+			res, err := finder.GetContainerResources(processes[0].Target.ContainerID)
+			if err != nil {
+				slog.Debug("Failed to get container resources", "namespace", ref.Namespace, "pod", ref.Pod, "container", ref.Container, "error", err)
+			}
+			setContainerLimitMetrics(ref, processes, cg, res, growth, now)
+			currentContainers[ref] = true
 		}
-	}
-}
 
-func collectAndSetMetrics(pid int, namespace, pod, container string) error {
-	comm, err := findComm(pid)
-	if err != nil {
-		return err
-	}
-	smapsPath := filepath.Join(*procPath, strconv.Itoa(pid), "smaps")
-	f, err := os.Open(smapsPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	mappings, err := ParseSmaps(f)
-	if err != nil {
-		return err
-	}
-	for _, m := range mappings {
-		setMetrics(comm, m)
+		currentPods := make(map[PodRef]bool)
+		for ref, processes := range pods {
+			setPodMetrics(ref, processes)
+			currentPods[ref] = true
+		}
+
+		var pfns map[sharedObject]map[ContainerRef]map[uint64]bool
+		if *sharedPFN {
+			var complete bool
+			pfns, complete = collectSharedPFNs(snapshots, *pagemapMaxPages)
+			if !complete {
+				slog.Warn("Failed to read the page frame numbers of some shared objects within -pagemap-max-pages, their shared memory is estimated")
+			}
+		}
+		report := BuildSharedMemoryReport(snapshots, pfns, now)
+		latestSharedMemoryReport.Store(report)
+		currentPairs := setSharedMemoryMetrics(report)
+
+		latestSnapshots.Store(&snapshots)
+		if history != nil {
+			history.Add(snapshots, now)
+		}
+
+		// Forget mappings, processes, containers and pods that have gone since the previous poll.
+		for _, ref := range removedMappings {
+			deleteMappingGrowthMetrics(ref)
+		}
+		for t := range previousTargets {
+			if !currentTargets[t] {
+				deleteProcessMetrics(t)
+			}
+		}
+		for ref := range previousContainers {
+			if !currentContainers[ref] {
+				deleteContainerMetrics(ref)
+				growth.Forget(ref)
+			}
+		}
+		for ref := range previousPods {
+			if !currentPods[ref] {
+				deletePodMetrics(ref)
+			}
+		}
+		for pair := range previousPairs {
+			if !currentPairs[pair] {
+				ContainerSharedMemory.DeleteLabelValues(append(containerLabelValues(pair[0]), containerLabelValues(pair[1])...)...)
+			}
+		}
+		previousTargets = currentTargets
+		previousNumaPolicies = currentNumaPolicies
+		previousContainers = currentContainers
+		previousPods = currentPods
+		previousPairs = currentPairs
+
+		if peaks != nil {
+			peaks.Observe()
+		}
 	}
-	return nil
 }
 
-func setMetrics(comm string, m *SmapsMapping) {
+func setMetrics(ps *ProcessSnapshot, m *SmapsMapping) {
+	comm := ps.Comm
 	ProcessSmapsSize.WithLabelValues(comm, m.Path).Set(float64(m.SizeBytes))
 	ProcessSmapsRss.WithLabelValues(comm, m.Path).Set(float64(m.RssBytes))
 	ProcessSmapsPss.WithLabelValues(comm, m.Path).Set(float64(m.PssBytes))
@@ -89,9 +245,385 @@ func setMetrics(comm string, m *SmapsMapping) {
 	ProcessSmapsPrivateHugetlb.WithLabelValues(comm, m.Path).Set(float64(m.PrivateHugetlbBytes))
 	ProcessSmapsSwap.WithLabelValues(comm, m.Path).Set(float64(m.SwapBytes))
 	ProcessSmapsSwapPss.WithLabelValues(comm, m.Path).Set(float64(m.SwapPssBytes))
+	ProcessSmapsLocked.WithLabelValues(comm, m.Path).Set(float64(m.LockedBytes))
 	ProcessSmapsKernelPageSize.WithLabelValues(comm, m.Path).Set(float64(m.KernelPageSizeBytes))
 	ProcessSmapsMMUPageSize.WithLabelValues(comm, m.Path).Set(float64(m.MMUPageSizeBytes))
-	ProcessSmapsLocked.WithLabelValues(comm, m.Path).Set(float64(m.LockedBytes))
+
+	// Unlike the series above, these are labelled by process, so that processes with the same comm do not overwrite each other.
+	mappingLabels := append(processLabelValues(ps.Target, ps.Comm), m.Path)
+	ProcessSmapsVMACount.WithLabelValues(mappingLabels...).Set(float64(m.VMACount))
+	ProcessSmapsKSM.WithLabelValues(mappingLabels...).Set(float64(m.KSMBytes))
+	if m.Mergeable() {
+		ProcessSmapsMergeable.WithLabelValues(mappingLabels...).Set(1)
+	} else {
+		ProcessSmapsMergeable.WithLabelValues(mappingLabels...).Set(0)
+	}
+	for size, rss := range m.RssBytesByPageSize {
+		pageSizeLabels := append(mappingLabels, strconv.FormatInt(size.KernelBytes, 10), strconv.FormatInt(size.MMUBytes, 10))
+		ProcessSmapsPageSizeInfo.WithLabelValues(pageSizeLabels...).Set(1)
+		ProcessSmapsRssByPageSize.WithLabelValues(pageSizeLabels...).Set(float64(rss))
+	}
+}
+
+func setAddressSpaceMetrics(ps *ProcessSnapshot, maxMapCount int) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	summary := SummarizeAddressSpace(ps.VMAs)
+	ProcessAddressSpaceVMACount.WithLabelValues(labels...).Set(float64(summary.VMACount))
+	ProcessAddressSpaceVirtual.WithLabelValues(labels...).Set(float64(summary.VirtualBytes))
+	ProcessAddressSpaceLargestGap.WithLabelValues(labels...).Set(float64(summary.LargestGapBytes))
+	if maxMapCount > 0 {
+		ProcessAddressSpaceVMAHeadroom.WithLabelValues(labels...).Set(float64(maxMapCount - summary.VMACount))
+	}
+
+	byCategory := make(map[string]int)
+	for _, vma := range ps.VMAs {
+		byCategory[MappingCategory(vma.Path)]++
+	}
+	for category, count := range byCategory {
+		ProcessAddressSpaceVMACountByCategory.WithLabelValues(append(labels, category)...).Set(float64(count))
+	}
+}
+
+func setProcessMemoryMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	ProcessMemoryRss.WithLabelValues(labels...).Set(float64(ps.Totals.RssBytes))
+	ProcessMemoryPss.WithLabelValues(labels...).Set(float64(ps.Totals.PssBytes))
+	ProcessMemoryUss.WithLabelValues(labels...).Set(float64(ps.Totals.UssBytes))
+	ProcessMemoryRssAnon.WithLabelValues(labels...).Set(float64(ps.Totals.RssAnonBytes))
+	ProcessMemoryRssFile.WithLabelValues(labels...).Set(float64(ps.Totals.RssFileBytes))
+	ProcessMemorySwap.WithLabelValues(labels...).Set(float64(ps.Totals.SwapBytes))
+	ProcessMemoryFootprint.WithLabelValues(labels...).Set(float64(ps.Totals.FootprintBytes))
+	ProcessMemoryReclaimable.WithLabelValues(labels...).Set(float64(ps.Totals.ReclaimableBytes))
+}
+
+func setProcessStatusMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	ProcessStatusVmPeak.WithLabelValues(labels...).Set(float64(ps.Status.VmPeakBytes))
+	ProcessStatusVmHWM.WithLabelValues(labels...).Set(float64(ps.Status.VmHWMBytes))
+	ProcessStatusRssAnon.WithLabelValues(labels...).Set(float64(ps.Status.RssAnonBytes))
+	ProcessStatusRssFile.WithLabelValues(labels...).Set(float64(ps.Status.RssFileBytes))
+	ProcessStatusRssShmem.WithLabelValues(labels...).Set(float64(ps.Status.RssShmemBytes))
+	ProcessStatusVmPTE.WithLabelValues(labels...).Set(float64(ps.Status.VmPTEBytes))
+	ProcessStatusVmSwap.WithLabelValues(labels...).Set(float64(ps.Status.VmSwapBytes))
+	ProcessStatusHugetlbPages.WithLabelValues(labels...).Set(float64(ps.Status.HugetlbPagesBytes))
+	ProcessStatusThreads.WithLabelValues(labels...).Set(float64(ps.Status.Threads))
+	ProcessStatusStatmSize.WithLabelValues(labels...).Set(float64(ps.Status.StatmSizeBytes))
+	ProcessStatusStatmResident.WithLabelValues(labels...).Set(float64(ps.Status.StatmResidentBytes))
+	ProcessStatusStatmShared.WithLabelValues(labels...).Set(float64(ps.Status.StatmSharedBytes))
+	ProcessStatusStatmText.WithLabelValues(labels...).Set(float64(ps.Status.StatmTextBytes))
+	ProcessStatusStatmData.WithLabelValues(labels...).Set(float64(ps.Status.StatmDataBytes))
+}
+
+func setKSMMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	ProcessKSMMergeable.WithLabelValues(labels...).Set(float64(MergeableBytes(ps.Mappings)))
+	if ps.KSM == nil {
+		return
+	}
+	pageSize := float64(os.Getpagesize())
+	ProcessKSMMerging.WithLabelValues(labels...).Set(float64(ps.KSM.MergingPages) * pageSize)
+	ProcessKSMZeroPages.WithLabelValues(labels...).Set(float64(ps.KSM.ZeroPages) * pageSize)
+	ProcessKSMRmapItems.WithLabelValues(labels...).Set(float64(ps.KSM.RmapItems))
+	ProcessKSMProfit.WithLabelValues(labels...).Set(float64(ps.KSM.ProfitBytes))
+}
+
+func setContainerKSMMetrics(ref ContainerRef, processes []*ProcessSnapshot) {
+	var merging, profit, mergeable int64
+	for _, ps := range processes {
+		mergeable += MergeableBytes(ps.Mappings)
+		if ps.KSM != nil {
+			merging += ps.KSM.MergingPages * int64(os.Getpagesize())
+			profit += ps.KSM.ProfitBytes
+		}
+	}
+	labels := containerLabelValues(ref)
+	ContainerKSMMerging.WithLabelValues(labels...).Set(float64(merging))
+	ContainerKSMProfit.WithLabelValues(labels...).Set(float64(profit))
+	ContainerKSMMergeable.WithLabelValues(labels...).Set(float64(mergeable))
+}
+
+// numaPolicySeries identifies a series of ProcessNumaMappingPolicy of a process.
+type numaPolicySeries struct {
+	comm, path string
+	policy     NumaPolicy
+}
+
+// setNumaMetrics sets the NUMA metrics of a process and returns its policy series.
+// The series in previous that the process no longer has are deleted, since the policy
+// of a mapping can change, e.g. with set_mempolicy.
+func setNumaMetrics(ps *ProcessSnapshot, previous map[numaPolicySeries]bool) map[numaPolicySeries]bool {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	nodeBytes := make(map[int]int64)
+	policies := make(map[numaPolicySeries]bool)
+	for path, stats := range ps.Numa {
+		for node, bytes := range stats.NodeBytes {
+			ProcessNumaMappingNode.WithLabelValues(append(labels, path, strconv.Itoa(node))...).Set(float64(bytes))
+			nodeBytes[node] += bytes
+		}
+		for policy := range stats.Policies {
+			ProcessNumaMappingPolicy.WithLabelValues(append(labels, path, policy.Policy, policy.Nodes)...).Set(1)
+			policies[numaPolicySeries{comm: ps.Comm, path: path, policy: policy}] = true
+		}
+	}
+	for series := range previous {
+		if !policies[series] {
+			ProcessNumaMappingPolicy.DeleteLabelValues(append(processLabelValues(ps.Target, series.comm), series.path, series.policy.Policy, series.policy.Nodes)...)
+		}
+	}
+	for node, bytes := range nodeBytes {
+		ProcessNumaNode.WithLabelValues(append(labels, strconv.Itoa(node))...).Set(float64(bytes))
+	}
+	return policies
+}
+
+func setPagemapMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	pageSize := float64(os.Getpagesize())
+	for path, stats := range ps.PageStats {
+		for kind, pages := range stats.Pages {
+			ProcessPagemap.WithLabelValues(append(labels, path, kind)...).Set(float64(pages) * pageSize)
+		}
+		for mapCount, pages := range stats.MapCount {
+			ProcessPagemapMapCount.WithLabelValues(append(labels, path, mapCount)...).Set(float64(pages) * pageSize)
+		}
+		if stats.Truncated {
+			ProcessPagemapTruncated.WithLabelValues(append(labels, path)...).Set(1)
+		} else {
+			ProcessPagemapTruncated.WithLabelValues(append(labels, path)...).Set(0)
+		}
+	}
+}
+
+func setWorkingSetMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	for path, ws := range ps.WorkingSet {
+		ProcessWorkingSet.WithLabelValues(append(labels, path, TemperatureHot)...).Set(float64(ws.HotBytes))
+		ProcessWorkingSet.WithLabelValues(append(labels, path, TemperatureWarm)...).Set(float64(ws.WarmBytes))
+		ProcessWorkingSet.WithLabelValues(append(labels, path, TemperatureCold)...).Set(float64(ws.ColdBytes))
+	}
+}
+
+func setContainerWorkingSetMetrics(ref ContainerRef, processes []*ProcessSnapshot) {
+	var total WorkingSet
+	found := false
+	for _, ps := range processes {
+		for _, ws := range ps.WorkingSet {
+			total.Add(ws)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	labels := containerLabelValues(ref)
+	ContainerWorkingSet.WithLabelValues(append(labels, TemperatureHot)...).Set(float64(total.HotBytes))
+	ContainerWorkingSet.WithLabelValues(append(labels, TemperatureWarm)...).Set(float64(total.WarmBytes))
+	ContainerWorkingSet.WithLabelValues(append(labels, TemperatureCold)...).Set(float64(total.ColdBytes))
+}
+
+func setSoftDirtyMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	for category, rate := range ps.DirtyRate {
+		ProcessSoftDirtyRate.WithLabelValues(append(labels, category)...).Set(rate)
+	}
+}
+
+func setContainerSoftDirtyMetrics(ref ContainerRef, processes []*ProcessSnapshot) {
+	rates := make(map[string]float64)
+	for _, ps := range processes {
+		for category, rate := range ps.DirtyRate {
+			rates[category] += rate
+		}
+	}
+	labels := containerLabelValues(ref)
+	for category, rate := range rates {
+		ContainerSoftDirtyRate.WithLabelValues(append(labels, category)...).Set(rate)
+	}
+}
+
+func setGrowthMetrics(ps *ProcessSnapshot) {
+	labels := processLabelValues(ps.Target, ps.Comm)
+	for path, g := range ps.Growth {
+		mappingLabels := append(labels, path)
+		for value, rate := range g.Rates {
+			ProcessMappingGrowthRate.WithLabelValues(append(mappingLabels, value)...).Set(rate)
+		}
+		ProcessMappingLeakScore.WithLabelValues(mappingLabels...).Set(g.LeakScore)
+	}
+}
+
+// deleteMappingGrowthMetrics removes the growth series of a mapping that has been unmapped.
+func deleteMappingGrowthMetrics(ref MappingRef) {
+	labels := prometheus.Labels{
+		"namespace": ref.Target.Namespace,
+		"pod":       ref.Target.Pod,
+		"container": ref.Target.Container,
+		"pid":       strconv.Itoa(ref.Target.PID),
+		"path":      ref.Path,
+	}
+	ProcessMappingGrowthRate.DeletePartialMatch(labels)
+	ProcessMappingLeakScore.DeletePartialMatch(labels)
+}
+
+func setContainerMetrics(ref ContainerRef, processes []*ProcessSnapshot) {
+	totals := SumMemoryTotals(processes)
+	labels := containerLabelValues(ref)
+	ContainerSmapsRss.WithLabelValues(labels...).Set(float64(totals.RssBytes))
+	ContainerSmapsPss.WithLabelValues(labels...).Set(float64(totals.PssBytes))
+	ContainerSmapsUss.WithLabelValues(labels...).Set(float64(totals.UssBytes))
+	ContainerSmapsRssAnon.WithLabelValues(labels...).Set(float64(totals.RssAnonBytes))
+	ContainerSmapsRssFile.WithLabelValues(labels...).Set(float64(totals.RssFileBytes))
+	ContainerSmapsSwap.WithLabelValues(labels...).Set(float64(totals.SwapBytes))
+	ContainerSmapsFootprint.WithLabelValues(labels...).Set(float64(totals.FootprintBytes))
+	ContainerSmapsReclaimable.WithLabelValues(labels...).Set(float64(totals.ReclaimableBytes))
+	ContainerSmapsRssSharedAware.WithLabelValues(labels...).Set(float64(SharedAwareRss(processes)))
+}
+
+func setContainerCgroupMetrics(ref ContainerRef, processes []*ProcessSnapshot, cg *CgroupMemory) {
+	labels := containerLabelValues(ref)
+	ContainerCgroupMemoryCurrent.WithLabelValues(labels...).Set(float64(cg.CurrentBytes))
+	ContainerCgroupMemoryMax.WithLabelValues(labels...).Set(cgroupLimitValue(cg.MaxBytes))
+	for stat, value := range cg.Stat {
+		ContainerCgroupMemoryStat.WithLabelValues(append(labels, stat)...).Set(float64(value))
+	}
+	for event, value := range cg.Events {
+		ContainerCgroupMemoryEvents.WithLabelValues(append(labels, event)...).Set(float64(value))
+	}
+
+	unexplained := ExplainCgroupMemory(cg, processes)
+	ContainerCgroupMemoryUnexplained.WithLabelValues(labels...).Set(float64(unexplained.TotalBytes))
+	ContainerCgroupMemoryUnexplainedByComponent.WithLabelValues(append(labels, "kernel")...).Set(float64(unexplained.KernelBytes))
+	ContainerCgroupMemoryUnexplainedByComponent.WithLabelValues(append(labels, "page_cache")...).Set(float64(unexplained.PageCacheBytes))
+	ContainerCgroupMemoryUnexplainedByComponent.WithLabelValues(append(labels, "shmem")...).Set(float64(unexplained.ShmemBytes))
+	ContainerCgroupMemoryUnexplainedByComponent.WithLabelValues(append(labels, "sock")...).Set(float64(unexplained.SockBytes))
+}
+
+// cgroupLimitValue converts a cgroup limit to a metric value, reporting unlimited as +Inf.
+func cgroupLimitValue(limit int64) float64 {
+	if limit == math.MaxInt64 {
+		return math.Inf(1)
+	}
+	return float64(limit)
+}
+
+func setContainerLimitMetrics(ref ContainerRef, processes []*ProcessSnapshot, cg *CgroupMemory, res *ContainerResources, growth *growthTracker[ContainerRef], now time.Time) {
+	labels := containerLabelValues(ref)
+	limits := ResolveMemoryLimits(res, cg)
+	if limits.RequestBytes > 0 {
+		ContainerSmapsMemoryRequest.WithLabelValues(labels...).Set(float64(limits.RequestBytes))
+	}
+	if limits.HighBytes > 0 {
+		ContainerSmapsMemoryHigh.WithLabelValues(labels...).Set(float64(limits.HighBytes))
+	}
+	if limits.LimitBytes == 0 {
+		ContainerSmapsMemoryLimit.WithLabelValues(labels...).Set(math.Inf(1))
+		return
+	}
+	ContainerSmapsMemoryLimit.WithLabelValues(labels...).Set(float64(limits.LimitBytes))
+
+	totals := SumMemoryTotals(processes)
+	limit := float64(limits.LimitBytes)
+	ContainerSmapsLimitUtilization.WithLabelValues(append(labels, "rss")...).Set(float64(SharedAwareRss(processes)) / limit)
+	ContainerSmapsLimitUtilization.WithLabelValues(append(labels, "pss")...).Set(float64(totals.PssBytes) / limit)
+	ContainerSmapsLimitUtilization.WithLabelValues(append(labels, "anon")...).Set(float64(totals.RssAnonBytes) / limit)
+
+	// Without cgroup data, anonymous memory is the best estimate of what cannot be reclaimed.
+	usage := totals.RssAnonBytes
+	if cg != nil {
+		usage = UnreclaimableBytes(cg)
+	}
+	headroom := max(0, limits.LimitBytes-usage)
+	ContainerSmapsAnonHeadroom.WithLabelValues(labels...).Set(float64(headroom))
+
+	growth.Add(ref, now, float64(usage))
+	if rate, ok := growth.Rate(ref); ok {
+		ContainerSmapsMemoryGrowthRate.WithLabelValues(labels...).Set(rate)
+		ContainerSmapsTimeToLimit.WithLabelValues(labels...).Set(timeToLimit(headroom, rate))
+	}
+}
+
+// setSharedMemoryMetrics exports the shared memory report and returns the container pairs it contains.
+func setSharedMemoryMetrics(report *SharedMemoryReport) map[[2]ContainerRef]bool {
+	pairs := make(map[[2]ContainerRef]bool)
+	for _, pair := range report.Pairs {
+		labels := append(containerLabelValues(pair.A), containerLabelValues(pair.B)...)
+		ContainerSharedMemory.WithLabelValues(labels...).Set(float64(pair.SharedBytes))
+		pairs[[2]ContainerRef{pair.A, pair.B}] = true
+	}
+	return pairs
+}
+
+func setPodMetrics(ref PodRef, processes []*ProcessSnapshot) {
+	totals := SumMemoryTotals(processes)
+	labels := podLabelValues(ref)
+	PodSmapsRss.WithLabelValues(labels...).Set(float64(totals.RssBytes))
+	PodSmapsPss.WithLabelValues(labels...).Set(float64(totals.PssBytes))
+	PodSmapsUss.WithLabelValues(labels...).Set(float64(totals.UssBytes))
+	PodSmapsRssAnon.WithLabelValues(labels...).Set(float64(totals.RssAnonBytes))
+	PodSmapsRssFile.WithLabelValues(labels...).Set(float64(totals.RssFileBytes))
+	PodSmapsSwap.WithLabelValues(labels...).Set(float64(totals.SwapBytes))
+	PodSmapsFootprint.WithLabelValues(labels...).Set(float64(totals.FootprintBytes))
+	PodSmapsReclaimable.WithLabelValues(labels...).Set(float64(totals.ReclaimableBytes))
+	PodSmapsRssSharedAware.WithLabelValues(labels...).Set(float64(SharedAwareRss(processes)))
+}
+
+// processLabelValues returns the label values matching processLabels.
+func processLabelValues(t Target, comm string) []string {
+	return []string{t.Namespace, t.Pod, t.Container, strconv.Itoa(t.PID), comm}
+}
+
+// deleteProcessMetrics removes all per-process series of the given target.
+func deleteProcessMetrics(t Target) {
+	labels := prometheus.Labels{
+		"namespace": t.Namespace,
+		"pod":       t.Pod,
+		"container": t.Container,
+		"pid":       strconv.Itoa(t.PID),
+	}
+	for _, m := range processMetrics {
+		m.DeletePartialMatch(labels)
+	}
+}
+
+// containerLabelValues returns the label values matching containerLabels.
+func containerLabelValues(ref ContainerRef) []string {
+	return []string{ref.Namespace, ref.Pod, ref.Container}
+}
+
+// deleteContainerMetrics removes all per-container series of the given container.
+func deleteContainerMetrics(ref ContainerRef) {
+	labels := prometheus.Labels{
+		"namespace": ref.Namespace,
+		"pod":       ref.Pod,
+		"container": ref.Container,
+	}
+	for _, m := range containerMetrics {
+		m.DeletePartialMatch(labels)
+	}
+}
+
+// podLabelValues returns the label values matching podLabels.
+func podLabelValues(ref PodRef) []string {
+	return []string{ref.Namespace, ref.Pod}
+}
+
+// deletePodMetrics removes all per-pod series of the given pod.
+func deletePodMetrics(ref PodRef) {
+	labels := prometheus.Labels{
+		"namespace": ref.Namespace,
+		"pod":       ref.Pod,
+	}
+	for _, m := range podMetrics {
+		m.DeletePartialMatch(labels)
+	}
+}
+
+// newFinder checks that the containerd socket exists and connects to it.
+func newFinder() (*KubernetesFinder, error) {
+	if _, err := os.Stat(*containerdSocketPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("the specified containerd socket %s does not exist", *containerdSocketPath)
+	}
+	return NewKubernetesPIDFinder(*containerdSocketPath, *procPath)
 }
 
 func findComm(pid int) (string, error) {
@@ -103,6 +635,28 @@ func findComm(pid int) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
+// readCmdline reads the command line arguments of the process, which are empty for kernel threads.
+func readCmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(*procPath, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(data), "\x00"), nil
+}
+
+// readMaxMapCount reads the system wide limit on the number of VMAs per process.
+func readMaxMapCount() (int, error) {
+	data, err := os.ReadFile(filepath.Join(*procPath, "sys", "vm", "max_map_count"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
 func parseLogLevel(level *string) slog.Level {
 	switch strings.ToLower(*level) {
 	case "debug":
@@ -132,33 +686,153 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Check that containerd socket exists.
-	if _, err := os.Stat(*containerdSocketPath); os.IsNotExist(err) {
-		slog.Error("The specified containerd socket does not exist", "containerdSocket", *containerdSocketPath)
-		os.Exit(1)
+	switch flag.Arg(0) {
+	case "":
+	case "inspect":
+		os.Exit(inspectCommand(flag.Args()[1:]))
+	case "snapshot":
+		os.Exit(snapshotCommand(flag.Args()[1:]))
+	case "diff":
+		os.Exit(diffCommand(flag.Args()[1:]))
+	case "record":
+		os.Exit(recordCommand(flag.Args()[1:]))
+	default:
+		slog.Error("Unknown command", "command", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	// Check that process filter is valid.
-	parts := strings.SplitN(*processFilter, "/", 4)
-	if len(parts) != 4 {
-		slog.Error("Invalid process filter format. Expected format: namespace/pod/container/command")
+	filter, err := ParseProcessFilter(*processFilter)
+	if err != nil {
+		slog.Error("Invalid process filter format. Expected format: namespace/pod/container/command", "error", err)
 		os.Exit(1)
 	}
 
-	// Initialize Kubernetes PID finder.
-	finder, err := NewKubernetesPIDFinder(*containerdSocketPath, *procPath)
-	if err != nil {
-		slog.Error("Failed to initialize Kubernetes PID finder", "error", err)
-		os.Exit(1)
+	// In replay mode the recording takes the place of /proc, the cgroup hierarchy and the container runtime.
+	var replay *ReplayFinder
+	if *replayPath != "" {
+		replay, err = OpenReplay(*replayPath, *replaySpeed)
+		if err != nil {
+			slog.Error("Failed to open replay", "error", err)
+			os.Exit(1)
+		}
+		*procPath = replay.ProcPath()
+		*cgroupPath = replay.CgroupPath()
+
+		// Remove the extracted recording on exit.
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			<-signals
+			replay.Close()
+			os.Exit(0)
+		}()
+	}
+
+	// Check that pagemap path filter is valid.
+	var pagemapFilter *regexp.Regexp
+	if *pagemapPaths != "" {
+		pagemapFilter, err = regexp.Compile(*pagemapPaths)
+		if err != nil {
+			slog.Error("Invalid pagemap path filter", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Check that idle page tracking path filter is valid.
+	var estimator *WorkingSetEstimator
+	if *idlePaths != "" {
+		idleFilter, err := regexp.Compile(*idlePaths)
+		if err != nil {
+			slog.Error("Invalid idle page tracking path filter", "error", err)
+			os.Exit(1)
+		}
+		estimator = NewWorkingSetEstimator(*procPath, *sysPath, idleFilter, *idleColdCycles, *idleMaxPages, *idlePagesPerSecond)
+		go estimator.Run(*idleInterval)
+	}
+
+	// Check that peak tracking metric filter is valid.
+	// The peak tracker is registered on its own registry, since collecting it
+	// ends the window: only scrapes of /metrics gather it, not remote write.
+	var peaks *PeakTracker
+	peakRegistry := prometheus.NewRegistry()
+	if *peakMetrics != "" {
+		peakFilter, err := regexp.Compile(*peakMetrics)
+		if err != nil {
+			slog.Error("Invalid peak tracking metric filter", "error", err)
+			os.Exit(1)
+		}
+		peaks = NewPeakTracker(peakFilter, prometheus.DefaultGatherer)
+		peakRegistry.MustRegister(peaks)
+	}
+
+	var finder Finder
+	if replay != nil {
+		finder = replay
+		go replay.Run()
+	} else {
+		k, err := newFinder()
+		if err != nil {
+			slog.Error("Failed to initialize Kubernetes PID finder", "error", err)
+			os.Exit(1)
+		}
+		finder = k
 	}
 
 	slog.Info("Starting smaps-exporter", "listenAddr", *listenAddr, "procPath", *procPath, "scrapeInterval", *interval)
 
-	go pollMetrics(finder, parts[0], parts[1], parts[2], parts[3])
+	var dirtyTracker *SoftDirtyTracker
+	if *softDirty {
+		dirtyTracker = NewSoftDirtyTracker(*procPath, *softDirtyInterval, *softDirtyMaxPages)
+	}
+
+	var leaks *LeakDetector
+	if *leakDetection {
+		leaks = NewLeakDetector(*leakWindow, *leakThreshold)
+	}
+
+	if *dedup {
+		go NewDuplicateAnalyzer(*procPath, *dedupMaxPages, *dedupPagesPerSecond).Run(*dedupInterval)
+	}
+
+	if *otlpEndpoint != "" {
+		exporter, err := NewOTLPExporter(*otlpProtocol, *otlpEndpoint, *otlpInsecure, *otlpTimeout)
+		if err != nil {
+			slog.Error("Failed to initialize OTLP exporter", "error", err)
+			os.Exit(1)
+		}
+		go exporter.Run(*otlpInterval)
+	}
+
+	if *remoteWriteURL != "" {
+		externalLabels, err := ParseExternalLabels(*remoteWriteLabels)
+		if err != nil {
+			slog.Error("Invalid remote write external labels", "error", err)
+			os.Exit(1)
+		}
+		writer := NewRemoteWriter(*remoteWriteURL, prometheus.DefaultGatherer, externalLabels, *remoteWriteQueueSize, *remoteWriteBatchSize, *remoteWriteRetries, *remoteWriteTimeout)
+		go writer.Run(*remoteWriteInterval)
+	}
+
+	var history *History
+	if *historyRetention > 0 {
+		history = NewHistory(*historyRetention, *interval)
+	}
+
+	go pollMetrics(finder, filter, pagemapFilter, estimator, dirtyTracker, leaks, peaks, history)
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/", http.RedirectHandler("/metrics", http.StatusFound))
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, peakRegistry}, promhttp.HandlerOpts{})))
+	mux.HandleFunc("/api/v1/shared", sharedMemoryHandler)
+	mux.HandleFunc("GET /api/v1/targets", targetListHandler(finder, filter))
+	mux.HandleFunc("GET /api/v1/targets/{namespace}/{pod}/{container}", targetsHandler(finder, filter))
+	mux.HandleFunc("GET /api/v1/pprof/{namespace}/{pod}/{container}", pprofHandler(finder, filter))
+	if history != nil {
+		mux.HandleFunc("GET /api/v1/history", historyHandler(history))
+	}
+	mux.Handle("/", uiHandler())
 
 	server := &http.Server{
 		Addr:    *listenAddr,
@@ -5,6 +5,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// processLabels identify a single process in per-process metrics.
+var processLabels = []string{"namespace", "pod", "container", "pid", "comm"}
+
+// containerLabels identify a single container in per-container metrics.
+var containerLabels = []string{"namespace", "pod", "container"}
+
+// podLabels identify a single pod in per-pod metrics.
+var podLabels = []string{"namespace", "pod"}
+
 // SmapsMetrics holds Prometheus Gauges for all /proc/[pid]/smaps fields.
 var (
 	ProcessSmapsSize = promauto.NewGaugeVec(
@@ -129,17 +138,31 @@ var (
 	ProcessSmapsKernelPageSize = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "process_smaps_kernel_page_size_bytes",
-			Help: "Kernel page size used for the mapping (bytes).",
+			Help: "Kernel page size used for the mapping, 0 if its VMAs use different page sizes (bytes).",
 		},
 		[]string{"comm", "path"},
 	)
 	ProcessSmapsMMUPageSize = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "process_smaps_mmu_page_size_bytes",
-			Help: "MMU page size used for the mapping (bytes).",
+			Help: "MMU page size used for the mapping, 0 if its VMAs use different page sizes (bytes).",
 		},
 		[]string{"comm", "path"},
 	)
+	ProcessSmapsPageSizeInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_smaps_page_size_info",
+			Help: "Kernel and MMU page sizes (bytes) of the VMAs in the mapping. Always 1.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "kernel_page_size", "mmu_page_size"},
+	)
+	ProcessSmapsRssByPageSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_smaps_rss_by_page_size_bytes",
+			Help: "Resident Set Size of the mapping broken down by the kernel and MMU page sizes of its VMAs (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "kernel_page_size", "mmu_page_size"},
+	)
 	ProcessSmapsLocked = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "process_smaps_locked_bytes",
@@ -147,4 +170,771 @@ var (
 		},
 		[]string{"comm", "path"},
 	)
+	ProcessSmapsKSM = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_smaps_ksm_bytes",
+			Help: "Amount of memory in the mapping merged by kernel samepage merging (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+	ProcessSmapsMergeable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_smaps_mergeable",
+			Help: "Whether the mapping is marked with madvise(MADV_MERGEABLE) for kernel samepage merging (mg in VmFlags). 1 if marked, 0 otherwise.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+	ProcessSmapsVMACount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_smaps_vma_count",
+			Help: "Number of VMAs (virtual memory areas) in the mapping.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+)
+
+// AddressSpaceMetrics holds Prometheus Gauges describing the virtual address space of each process.
+var (
+	ProcessAddressSpaceVMACount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_address_space_vma_count",
+			Help: "Total number of VMAs (virtual memory areas) in the process.",
+		},
+		processLabels,
+	)
+	ProcessAddressSpaceVMACountByCategory = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_address_space_vma_count_by_category",
+			Help: "Number of VMAs in the process by mapping category (heap, stack, anon, file, shmem, device, special).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "category"},
+	)
+	ProcessAddressSpaceVirtual = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_address_space_virtual_bytes",
+			Help: "Total virtual size of all mappings in the process (bytes).",
+		},
+		processLabels,
+	)
+	ProcessAddressSpaceLargestGap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_address_space_largest_gap_bytes",
+			Help: "Largest contiguous unmapped gap between two mappings of the process (bytes).",
+		},
+		processLabels,
+	)
+	ProcessAddressSpaceVMAHeadroom = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_address_space_vma_headroom",
+			Help: "Number of VMAs the process can still create before reaching vm.max_map_count.",
+		},
+		processLabels,
+	)
+	VMMaxMapCount = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "vm_max_map_count",
+			Help: "Maximum number of VMAs a process may have, from /proc/sys/vm/max_map_count.",
+		},
+	)
+)
+
+// ProcessMemoryMetrics holds Prometheus Gauges for the memory accounting figures of each process.
+var (
+	ProcessMemoryRss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_rss_bytes",
+			Help: "Resident Set Size of all mappings (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryPss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_pss_bytes",
+			Help: "Proportional Set Size of all mappings (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryUss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_uss_bytes",
+			Help: "Unique Set Size: private clean and dirty pages, freed if the process exits (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryRssAnon = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_rss_anon_bytes",
+			Help: "Resident anonymous memory (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryRssFile = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_rss_file_bytes",
+			Help: "Resident file-backed and shared memory (shmem) (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemorySwap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_swap_bytes",
+			Help: "Anonymous memory swapped out (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryFootprint = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_footprint_bytes",
+			Help: "Swap-inclusive proportional footprint: PSS plus SwapPss (bytes).",
+		},
+		processLabels,
+	)
+	ProcessMemoryReclaimable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_memory_reclaimable_bytes",
+			Help: "Estimate of clean file-backed pages that can be reclaimed without writeback (bytes).",
+		},
+		processLabels,
+	)
+)
+
+// ContainerSmapsMetrics holds Prometheus Gauges for the memory accounting figures of each container,
+// summed over its processes. RSS based figures count pages shared between processes once per process.
+var (
+	ContainerSmapsRss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_rss_bytes",
+			Help: "Resident Set Size summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsPss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_pss_bytes",
+			Help: "Proportional Set Size summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsUss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_uss_bytes",
+			Help: "Unique Set Size summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsRssAnon = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_rss_anon_bytes",
+			Help: "Resident anonymous memory summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsRssFile = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_rss_file_bytes",
+			Help: "Resident file-backed and shared memory (shmem) summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsSwap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_swap_bytes",
+			Help: "Anonymous memory swapped out, summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsFootprint = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_footprint_bytes",
+			Help: "Swap-inclusive proportional footprint (PSS plus SwapPss) summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsReclaimable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_reclaimable_bytes",
+			Help: "Estimate of clean file-backed pages that can be reclaimed, summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsRssSharedAware = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_rss_shared_aware_bytes",
+			Help: "Resident Set Size of the container, counting file-backed pages of each (dev, inode) object once (bytes).",
+		},
+		containerLabels,
+	)
+)
+
+// ProcessStatusMetrics holds Prometheus Gauges for the kernel's per-process counters
+// from /proc/[pid]/status and /proc/[pid]/statm.
+var (
+	ProcessStatusVmPeak = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_vm_peak_bytes",
+			Help: "Peak virtual memory size, VmPeak from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusVmHWM = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_vm_hwm_bytes",
+			Help: "Peak resident set size (high-water mark), VmHWM from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusRssAnon = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_rss_anon_bytes",
+			Help: "Resident anonymous memory, RssAnon from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusRssFile = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_rss_file_bytes",
+			Help: "Resident file mappings, RssFile from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusRssShmem = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_rss_shmem_bytes",
+			Help: "Resident shared memory, RssShmem from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusVmPTE = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_vm_pte_bytes",
+			Help: "Size of page table entries, VmPTE from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusVmSwap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_vm_swap_bytes",
+			Help: "Swapped-out anonymous memory, VmSwap from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusHugetlbPages = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_hugetlb_pages_bytes",
+			Help: "Memory backed by hugetlbfs pages, HugetlbPages from /proc/[pid]/status (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusThreads = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_status_threads",
+			Help: "Number of threads, Threads from /proc/[pid]/status.",
+		},
+		processLabels,
+	)
+	ProcessStatusStatmSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_statm_size_bytes",
+			Help: "Total program size, from /proc/[pid]/statm (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusStatmResident = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_statm_resident_bytes",
+			Help: "Resident set size, from /proc/[pid]/statm (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusStatmShared = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_statm_shared_bytes",
+			Help: "Resident file-backed and shared pages, from /proc/[pid]/statm (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusStatmText = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_statm_text_bytes",
+			Help: "Size of the text (code) segment, from /proc/[pid]/statm (bytes).",
+		},
+		processLabels,
+	)
+	ProcessStatusStatmData = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_statm_data_bytes",
+			Help: "Size of data and stack, from /proc/[pid]/statm (bytes).",
+		},
+		processLabels,
+	)
+)
+
+// KSMMetrics holds Prometheus Gauges for the kernel samepage merging counters of each process and container.
+var (
+	ProcessKSMMerging = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_ksm_merging_bytes",
+			Help: "Memory of the process merged by kernel samepage merging, from ksm_merging_pages (bytes).",
+		},
+		processLabels,
+	)
+	ProcessKSMZeroPages = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_ksm_zero_pages_bytes",
+			Help: "Memory of the process merged with the kernel zero page by KSM, from ksm_stat (bytes).",
+		},
+		processLabels,
+	)
+	ProcessKSMRmapItems = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_ksm_rmap_items",
+			Help: "Number of KSM reverse mapping items of the process, from ksm_stat.",
+		},
+		processLabels,
+	)
+	ProcessKSMProfit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_ksm_profit_bytes",
+			Help: "Memory saved by KSM for the process, minus the KSM metadata overhead, from ksm_stat (bytes).",
+		},
+		processLabels,
+	)
+	ProcessKSMMergeable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_ksm_mergeable_bytes",
+			Help: "Resident memory of the process in mappings marked with madvise(MADV_MERGEABLE) (bytes).",
+		},
+		processLabels,
+	)
+	ContainerKSMMerging = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_ksm_merging_bytes",
+			Help: "Memory merged by kernel samepage merging summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerKSMProfit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_ksm_profit_bytes",
+			Help: "Memory saved by KSM summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerKSMMergeable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_ksm_mergeable_bytes",
+			Help: "Resident memory in mappings marked with madvise(MADV_MERGEABLE) summed over the processes of the container (bytes).",
+		},
+		containerLabels,
+	)
+)
+
+// NumaMetrics holds Prometheus Gauges for the NUMA placement of each process from /proc/[pid]/numa_maps.
+var (
+	ProcessNumaNode = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_numa_node_bytes",
+			Help: "Resident memory of the process on each NUMA node (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "node"},
+	)
+	ProcessNumaMappingNode = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_numa_mapping_node_bytes",
+			Help: "Resident memory of the mapping on each NUMA node (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "node"},
+	)
+	ProcessNumaMappingPolicy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_numa_mapping_policy_info",
+			Help: "Memory policy of the VMAs in the mapping (default, bind, interleave, prefer, local) and the nodes it applies to. Always 1.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "policy", "nodes"},
+	)
+)
+
+// PagemapMetrics holds Prometheus Gauges for the page level analysis of selected mappings.
+var (
+	ProcessPagemap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_pagemap_bytes",
+			Help: "Pages of the mapping by kind (present, swapped, thp, ksm, zero_page, swapcache, dirty, lru_active, lru_inactive), from pagemap and kpageflags (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "kind"},
+	)
+	ProcessPagemapMapCount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_pagemap_mapcount_bytes",
+			Help: "Present pages of the mapping by the number of times they are mapped, from kpagecount (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "mapcount"},
+	)
+	ProcessPagemapTruncated = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_pagemap_truncated",
+			Help: "Whether the page budget ran out before all pages of the mapping were analyzed, so that process_pagemap_bytes covers only part of it. 1 if truncated, 0 otherwise.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+)
+
+// LeakMetrics holds Prometheus Gauges for the growth trend of mappings.
+var (
+	ProcessMappingGrowthRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_mapping_growth_rate_bytes_per_second",
+			Help: "Growth rate of the mapping over the leak detection window, by value: rss, pss or anon (bytes per second).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "value"},
+	)
+	ProcessMappingLeakScore = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_mapping_leak_suspect_score",
+			Help: "Score between 0 and 1 of how steadily the mapping has grown over the leak detection window.",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+)
+
+// SoftDirtyMetrics holds Prometheus Gauges for the write rates measured with soft-dirty bits.
+var (
+	ProcessSoftDirtyRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_soft_dirty_bytes_per_second",
+			Help: "Memory of the process written during the last soft-dirty interval by mapping category (bytes per second).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "category"},
+	)
+	ContainerSoftDirtyRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_soft_dirty_bytes_per_second",
+			Help: "Memory of the container written during the last soft-dirty interval by mapping category (bytes per second).",
+		},
+		[]string{"namespace", "pod", "container", "category"},
+	)
+)
+
+// DuplicateMetrics holds Prometheus Gauges for the duplicate content analysis of anonymous mappings.
+// All figures are counted among the sampled pages.
+var (
+	ProcessDuplicateSampled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_dedup_sampled_bytes",
+			Help: "Pages of the anonymous mapping sampled by the duplicate content analysis (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+	ProcessDuplicateZero = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_dedup_zero_bytes",
+			Help: "Sampled pages of the anonymous mapping filled with zeros (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+	ProcessDuplicateWithinProcess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_dedup_duplicate_bytes",
+			Help: "Sampled pages of the anonymous mapping whose content is duplicated within the process (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+	ProcessDuplicateCrossContainer = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_dedup_cross_container_bytes",
+			Help: "Sampled pages of the anonymous mapping whose content also appears in another container, the potential KSM savings (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path"},
+	)
+)
+
+// WorkingSetMetrics holds Prometheus Gauges for the idle page tracking based working set estimation.
+var (
+	ProcessWorkingSet = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "process_working_set_bytes",
+			Help: "Resident pages of the mapping by access temperature (hot, warm, cold), from idle page tracking (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "pid", "comm", "path", "temperature"},
+	)
+	ContainerWorkingSet = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_working_set_bytes",
+			Help: "Resident pages of the analyzed mappings of the container by access temperature (hot, warm, cold), from idle page tracking (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "temperature"},
+	)
 )
+
+// PodSmapsMetrics holds Prometheus Gauges for the memory accounting figures of each pod,
+// summed over the processes of all its containers.
+var (
+	PodSmapsRss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_rss_bytes",
+			Help: "Resident Set Size summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsPss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_pss_bytes",
+			Help: "Proportional Set Size summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsUss = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_uss_bytes",
+			Help: "Unique Set Size summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsRssAnon = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_rss_anon_bytes",
+			Help: "Resident anonymous memory summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsRssFile = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_rss_file_bytes",
+			Help: "Resident file-backed and shared memory (shmem) summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsSwap = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_swap_bytes",
+			Help: "Anonymous memory swapped out, summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsFootprint = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_footprint_bytes",
+			Help: "Swap-inclusive proportional footprint (PSS plus SwapPss) summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsReclaimable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_reclaimable_bytes",
+			Help: "Estimate of clean file-backed pages that can be reclaimed, summed over the processes of the pod (bytes).",
+		},
+		podLabels,
+	)
+	PodSmapsRssSharedAware = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_smaps_rss_shared_aware_bytes",
+			Help: "Resident Set Size of the pod, counting file-backed pages of each (dev, inode) object once (bytes).",
+		},
+		podLabels,
+	)
+)
+
+// ContainerCgroupMetrics holds Prometheus Gauges for the cgroup v2 memory accounting of each container.
+var (
+	ContainerCgroupMemoryCurrent = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_current_bytes",
+			Help: "Memory charged to the container cgroup, from memory.current (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerCgroupMemoryMax = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_max_bytes",
+			Help: "Memory limit of the container cgroup, from memory.max. +Inf when unlimited (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerCgroupMemoryStat = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_stat",
+			Help: "Entries of the container cgroup memory.stat file. Memory amounts are in bytes, event counts are cumulative.",
+		},
+		[]string{"namespace", "pod", "container", "stat"},
+	)
+	ContainerCgroupMemoryEvents = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_events",
+			Help: "Cumulative event counts from the container cgroup memory.events file (low, high, max, oom, oom_kill).",
+		},
+		[]string{"namespace", "pod", "container", "event"},
+	)
+	ContainerCgroupMemoryUnexplained = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_unexplained_bytes",
+			Help: "Memory charged to the container cgroup that is not explained by the resident mappings of its processes (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerCgroupMemoryUnexplainedByComponent = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_cgroup_memory_unexplained_by_component_bytes",
+			Help: "Memory charged to the container cgroup that is not mapped by its processes, by component (kernel, page_cache, shmem, sock) (bytes).",
+		},
+		[]string{"namespace", "pod", "container", "component"},
+	)
+)
+
+// ContainerLimitMetrics holds Prometheus Gauges for the memory limits of each container and how close the container is to them.
+var (
+	ContainerSmapsMemoryLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_memory_limit_bytes",
+			Help: "Memory limit of the container from the runtime or cgroup memory.max. +Inf when unlimited (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsMemoryRequest = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_memory_request_bytes",
+			Help: "Memory request of the container, from memory.min set by the kubelet when MemoryQoS is enabled (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsMemoryHigh = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_memory_high_bytes",
+			Help: "Memory throttling threshold of the container, from cgroup memory.high (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsLimitUtilization = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_limit_utilization_ratio",
+			Help: "Memory usage of the container as a fraction of its limit, by basis (rss, pss, anon).",
+		},
+		[]string{"namespace", "pod", "container", "basis"},
+	)
+	ContainerSmapsAnonHeadroom = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_anon_headroom_bytes",
+			Help: "Anonymous memory the container can still allocate before reaching its limit, assuming page cache is reclaimed (bytes).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsMemoryGrowthRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_memory_growth_rate_bytes_per_second",
+			Help: "Growth rate of the unreclaimable memory of the container over the growth window (bytes per second).",
+		},
+		containerLabels,
+	)
+	ContainerSmapsTimeToLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_smaps_time_to_limit_seconds",
+			Help: "Projected time until the container reaches its memory limit at the current growth rate. +Inf when not growing (seconds).",
+		},
+		containerLabels,
+	)
+)
+
+// SharedMemoryMetrics holds Prometheus Gauges for the memory shared between pairs of containers.
+var (
+	ContainerSharedMemory = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_shared_memory_bytes",
+			Help: "Resident file-backed and shmem memory shared by a pair of containers (bytes).",
+		},
+		[]string{"namespace_a", "pod_a", "container_a", "namespace_b", "pod_b", "container_b"},
+	)
+)
+
+// processMetrics lists all metrics labelled with processLabels, so that series of exited processes can be deleted.
+var processMetrics = []*prometheus.GaugeVec{
+	ProcessSmapsPageSizeInfo,
+	ProcessSmapsRssByPageSize,
+	ProcessSmapsKSM,
+	ProcessSmapsMergeable,
+	ProcessSmapsVMACount,
+	ProcessAddressSpaceVMACount,
+	ProcessAddressSpaceVMACountByCategory,
+	ProcessAddressSpaceVirtual,
+	ProcessAddressSpaceLargestGap,
+	ProcessAddressSpaceVMAHeadroom,
+	ProcessMemoryRss,
+	ProcessMemoryPss,
+	ProcessMemoryUss,
+	ProcessMemoryRssAnon,
+	ProcessMemoryRssFile,
+	ProcessMemorySwap,
+	ProcessMemoryFootprint,
+	ProcessMemoryReclaimable,
+	ProcessStatusVmPeak,
+	ProcessStatusVmHWM,
+	ProcessStatusRssAnon,
+	ProcessStatusRssFile,
+	ProcessStatusRssShmem,
+	ProcessStatusVmPTE,
+	ProcessStatusVmSwap,
+	ProcessStatusHugetlbPages,
+	ProcessStatusThreads,
+	ProcessStatusStatmSize,
+	ProcessStatusStatmResident,
+	ProcessStatusStatmShared,
+	ProcessStatusStatmText,
+	ProcessStatusStatmData,
+	ProcessKSMMerging,
+	ProcessKSMZeroPages,
+	ProcessKSMRmapItems,
+	ProcessKSMProfit,
+	ProcessKSMMergeable,
+	ProcessNumaNode,
+	ProcessNumaMappingNode,
+	ProcessNumaMappingPolicy,
+	ProcessPagemap,
+	ProcessPagemapMapCount,
+	ProcessPagemapTruncated,
+	ProcessWorkingSet,
+	ProcessSoftDirtyRate,
+	ProcessMappingGrowthRate,
+	ProcessMappingLeakScore,
+}
+
+// containerMetrics lists all metrics labelled with containerLabels, so that series of removed containers can be deleted.
+var containerMetrics = []*prometheus.GaugeVec{
+	ContainerSmapsRss,
+	ContainerSmapsPss,
+	ContainerSmapsUss,
+	ContainerSmapsRssAnon,
+	ContainerSmapsRssFile,
+	ContainerSmapsSwap,
+	ContainerSmapsFootprint,
+	ContainerSmapsReclaimable,
+	ContainerSmapsRssSharedAware,
+	ContainerCgroupMemoryCurrent,
+	ContainerCgroupMemoryMax,
+	ContainerCgroupMemoryStat,
+	ContainerCgroupMemoryEvents,
+	ContainerCgroupMemoryUnexplained,
+	ContainerCgroupMemoryUnexplainedByComponent,
+	ContainerSmapsMemoryLimit,
+	ContainerSmapsMemoryRequest,
+	ContainerSmapsMemoryHigh,
+	ContainerSmapsLimitUtilization,
+	ContainerSmapsAnonHeadroom,
+	ContainerSmapsMemoryGrowthRate,
+	ContainerSmapsTimeToLimit,
+	ContainerWorkingSet,
+	ContainerSoftDirtyRate,
+	ContainerKSMMerging,
+	ContainerKSMProfit,
+	ContainerKSMMergeable,
+}
+
+// podMetrics lists all metrics labelled with podLabels, so that series of removed pods can be deleted.
+var podMetrics = []*prometheus.GaugeVec{
+	PodSmapsRss,
+	PodSmapsPss,
+	PodSmapsUss,
+	PodSmapsRssAnon,
+	PodSmapsRssFile,
+	PodSmapsSwap,
+	PodSmapsFootprint,
+	PodSmapsReclaimable,
+	PodSmapsRssSharedAware,
+}
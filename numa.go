@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NumaMapping describes a memory mapping entry parsed from /proc/[pid]/numa_maps.
+type NumaMapping struct {
+	Start uint64
+
+	// Policy is the memory policy of the mapping (default, bind, interleave, prefer, local, ...)
+	// and PolicyNodes the node list the policy applies to, if any.
+	Policy      string
+	PolicyNodes string
+
+	PageSizeBytes int64
+
+	// NodeBytes holds the resident bytes on each NUMA node.
+	NodeBytes map[int]int64
+}
+
+// NumaPolicy is a memory policy and the node list it applies to, e.g. bind and 0-1.
+type NumaPolicy struct {
+	Policy string
+	Nodes  string
+}
+
+// NumaStats holds the NUMA placement of a mapping aggregated over its VMAs.
+type NumaStats struct {
+	Policies  map[NumaPolicy]bool
+	NodeBytes map[int]int64
+}
+
+// ParseNumaMaps parses the contents of a /proc/[pid]/numa_maps file.
+func ParseNumaMaps(r io.Reader) ([]*NumaMapping, error) {
+	var mappings []*NumaMapping
+
+	// 7f1c2e9f5000 default file=/usr/lib/x86_64-linux-gnu/libc.so.6 mapped=40 mapmax=30 N0=40 kernelpagesize_kB=4
+	// 55d0c2a8c000 interleave:0-1 heap anon=30 dirty=30 N0=20 N1=10 kernelpagesize_kB=4
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		start, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		m := &NumaMapping{
+			Start:         start,
+			PageSizeBytes: 4096,
+			NodeBytes:     make(map[int]int64),
+		}
+		m.Policy, m.PolicyNodes, _ = strings.Cut(fields[1], ":")
+
+		// Page counts can appear before kernelpagesize_kB, so they are converted to bytes last.
+		nodePages := make(map[int]int64)
+		for _, field := range fields[2:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			switch {
+			case key == "kernelpagesize_kB":
+				if kb, err := strconv.ParseInt(value, 10, 64); err == nil {
+					m.PageSizeBytes = kb * 1024
+				}
+			case strings.HasPrefix(key, "N"):
+				node, err := strconv.Atoi(key[1:])
+				if err != nil {
+					continue
+				}
+				if pages, err := strconv.ParseInt(value, 10, 64); err == nil {
+					nodePages[node] = pages
+				}
+			}
+		}
+		for node, pages := range nodePages {
+			m.NodeBytes[node] = pages * m.PageSizeBytes
+		}
+		mappings = append(mappings, m)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return mappings, nil
+}
+
+// JoinNumaMaps joins numa_maps entries to the VMAs returned by ParseSmapsVMAs by start address,
+// and aggregates them by path like AggregateSmaps.
+func JoinNumaMaps(vmas []*SmapsMapping, numa []*NumaMapping) map[string]*NumaStats {
+	byStart := make(map[uint64]*NumaMapping, len(numa))
+	for _, n := range numa {
+		byStart[n.Start] = n
+	}
+
+	result := make(map[string]*NumaStats)
+	for _, vma := range vmas {
+		start, _, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		n, found := byStart[start]
+		if !found {
+			continue
+		}
+		stats, found := result[vma.Path]
+		if !found {
+			stats = &NumaStats{Policies: make(map[NumaPolicy]bool), NodeBytes: make(map[int]int64)}
+			result[vma.Path] = stats
+		}
+		stats.Policies[NumaPolicy{Policy: n.Policy, Nodes: n.PolicyNodes}] = true
+		for node, bytes := range n.NodeBytes {
+			stats.NodeBytes[node] += bytes
+		}
+	}
+	return result
+}
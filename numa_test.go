@@ -0,0 +1,92 @@
+package main
+
+import (
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNumaMaps = `55d0c2a8c000 bind:1 heap anon=30 dirty=30 N1=30 kernelpagesize_kB=4
+7f1c2e800000 interleave:0-1 anon=1024 dirty=1024 N0=512 N1=512 kernelpagesize_kB=2048
+7f1c2e9f5000 default file=/usr/lib/x86_64-linux-gnu/libc.so.6 mapped=40 mapmax=30 N0=40 kernelpagesize_kB=4
+7f1c2ea00000 default file=/usr/lib/x86_64-linux-gnu/libc.so.6 mapped=10 N0=4 N1=6 kernelpagesize_kB=4
+7ffd1e9d6000 prefer:0 stack anon=3 dirty=3 N0=3 kernelpagesize_kB=4
+`
+
+func TestParseNumaMaps(t *testing.T) {
+	mappings, err := ParseNumaMaps(strings.NewReader(testNumaMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*NumaMapping{
+		{Start: 0x55d0c2a8c000, Policy: "bind", PolicyNodes: "1", PageSizeBytes: 4096, NodeBytes: map[int]int64{1: 30 * 4096}},
+		{Start: 0x7f1c2e800000, Policy: "interleave", PolicyNodes: "0-1", PageSizeBytes: 2 << 20, NodeBytes: map[int]int64{0: 512 * 2 << 20, 1: 512 * 2 << 20}},
+		{Start: 0x7f1c2e9f5000, Policy: "default", PageSizeBytes: 4096, NodeBytes: map[int]int64{0: 40 * 4096}},
+		{Start: 0x7f1c2ea00000, Policy: "default", PageSizeBytes: 4096, NodeBytes: map[int]int64{0: 4 * 4096, 1: 6 * 4096}},
+		{Start: 0x7ffd1e9d6000, Policy: "prefer", PolicyNodes: "0", PageSizeBytes: 4096, NodeBytes: map[int]int64{0: 3 * 4096}},
+	}
+	if len(mappings) != len(want) {
+		t.Fatalf("got %d mappings, want %d", len(mappings), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(mappings[i], want[i]) {
+			t.Errorf("mapping %d: got %+v, want %+v", i, mappings[i], want[i])
+		}
+	}
+}
+
+func TestJoinNumaMaps(t *testing.T) {
+	numa, err := ParseNumaMaps(strings.NewReader(testNumaMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmas := []*SmapsMapping{
+		{AddrRange: "55d0c2a8c000-55d0c2aaa000", Path: "[heap]"},
+		{AddrRange: "7f1c2e9f5000-7f1c2ea00000", Path: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+		{AddrRange: "7f1c2ea00000-7f1c2ea0a000", Path: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+		// A VMA without a numa_maps entry, e.g. created after numa_maps was read.
+		{AddrRange: "7f1c2f000000-7f1c2f001000", Path: "[anon]"},
+	}
+	got := JoinNumaMaps(vmas, numa)
+
+	want := map[string]*NumaStats{
+		"[heap]": {
+			Policies:  map[NumaPolicy]bool{{Policy: "bind", Nodes: "1"}: true},
+			NodeBytes: map[int]int64{1: 30 * 4096},
+		},
+		"/usr/lib/x86_64-linux-gnu/libc.so.6": {
+			Policies:  map[NumaPolicy]bool{{Policy: "default"}: true},
+			NodeBytes: map[int]int64{0: 44 * 4096, 1: 6 * 4096},
+		},
+	}
+	if !maps.EqualFunc(got, want, func(a, b *NumaStats) bool { return reflect.DeepEqual(a, b) }) {
+		for path, stats := range got {
+			t.Errorf("got %s: %+v", path, stats)
+		}
+	}
+}
+
+func TestSetNumaMetricsDeletesChangedPolicies(t *testing.T) {
+	ps := &ProcessSnapshot{
+		Target: Target{Namespace: "numa-test", Pod: "app-0", Container: "app", PID: 42},
+		Comm:   "app",
+		Numa: map[string]*NumaStats{
+			"[heap]": {Policies: map[NumaPolicy]bool{{Policy: "bind", Nodes: "0"}: true}},
+		},
+	}
+	t.Cleanup(func() { deleteProcessMetrics(ps.Target) })
+	policies := setNumaMetrics(ps, nil)
+
+	// The policy is changed with set_mempolicy to bind to another node.
+	ps.Numa["[heap]"].Policies = map[NumaPolicy]bool{{Policy: "bind", Nodes: "1"}: true}
+	setNumaMetrics(ps, policies)
+
+	labels := append(processLabelValues(ps.Target, ps.Comm), "[heap]", "bind")
+	if ProcessNumaMappingPolicy.DeleteLabelValues(append(labels, "0")...) {
+		t.Error("series of the previous policy was not deleted")
+	}
+	if !ProcessNumaMappingPolicy.DeleteLabelValues(append(labels, "1")...) {
+		t.Error("series of the current policy not found")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP transport protocols supported by OTLPExporter.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// otlpScopeName is the instrumentation scope of the exported metrics.
+const otlpScopeName = "github.com/tsaarni/smaps-container-exporter"
+
+// otlpMappingMetrics lists the smaps fields exported per mapping.
+var otlpMappingMetrics = []struct {
+	name        string
+	description string
+	value       func(m *SmapsMapping) int64
+}{
+	{"smaps.mapping.size", "Total size of the memory mapping.", func(m *SmapsMapping) int64 { return m.SizeBytes }},
+	{"smaps.mapping.rss", "Resident Set Size of the mapping.", func(m *SmapsMapping) int64 { return m.RssBytes }},
+	{"smaps.mapping.pss", "Proportional Set Size of the mapping.", func(m *SmapsMapping) int64 { return m.PssBytes }},
+	{"smaps.mapping.shared_clean", "Clean shared pages of the mapping.", func(m *SmapsMapping) int64 { return m.SharedCleanBytes }},
+	{"smaps.mapping.shared_dirty", "Dirty shared pages of the mapping.", func(m *SmapsMapping) int64 { return m.SharedDirtyBytes }},
+	{"smaps.mapping.private_clean", "Clean private pages of the mapping.", func(m *SmapsMapping) int64 { return m.PrivateCleanBytes }},
+	{"smaps.mapping.private_dirty", "Dirty private pages of the mapping.", func(m *SmapsMapping) int64 { return m.PrivateDirtyBytes }},
+	{"smaps.mapping.anonymous", "Anonymous pages of the mapping.", func(m *SmapsMapping) int64 { return m.AnonymousBytes }},
+	{"smaps.mapping.swap", "Swapped out anonymous pages of the mapping.", func(m *SmapsMapping) int64 { return m.SwapBytes }},
+	{"smaps.mapping.swap_pss", "Proportional share of the swapped out pages of the mapping.", func(m *SmapsMapping) int64 { return m.SwapPssBytes }},
+}
+
+// otlpProcessMetrics lists the totals exported per process.
+var otlpProcessMetrics = []struct {
+	name        string
+	description string
+	value       func(t *MemoryTotals) int64
+}{
+	{"smaps.process.rss", "Resident Set Size of the process.", func(t *MemoryTotals) int64 { return t.RssBytes }},
+	{"smaps.process.pss", "Proportional Set Size of the process.", func(t *MemoryTotals) int64 { return t.PssBytes }},
+	{"smaps.process.uss", "Unique Set Size of the process.", func(t *MemoryTotals) int64 { return t.UssBytes }},
+	{"smaps.process.swap", "Swapped out memory of the process.", func(t *MemoryTotals) int64 { return t.SwapBytes }},
+	{"smaps.process.footprint", "PSS plus proportional swap of the process.", func(t *MemoryTotals) int64 { return t.FootprintBytes }},
+}
+
+// OTLPExporter pushes the latest snapshots to an OpenTelemetry collector with OTLP.
+//
+// Each process is exported as a resource with the Kubernetes and process
+// semantic convention attributes, and the smaps fields of its mappings as
+// gauges with the path and category as data point attributes.
+type OTLPExporter struct {
+	protocol string
+	endpoint string
+	timeout  time.Duration
+
+	client     collectormetrics.MetricsServiceClient
+	httpClient *http.Client
+}
+
+// NewOTLPExporter creates an exporter for endpoint. With grpc the endpoint is host:port and
+// insecure disables TLS, with http it is the URL of the metrics path, for example
+// http://otel-collector:4318/v1/metrics.
+func NewOTLPExporter(protocol, endpoint string, insecureTransport bool, timeout time.Duration) (*OTLPExporter, error) {
+	e := &OTLPExporter{
+		protocol: protocol,
+		endpoint: endpoint,
+		timeout:  timeout,
+	}
+	switch protocol {
+	case OTLPProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{})
+		if insecureTransport {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to OTLP endpoint: %w", err)
+		}
+		e.client = collectormetrics.NewMetricsServiceClient(conn)
+	case OTLPProtocolHTTP:
+		e.httpClient = &http.Client{Timeout: timeout}
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", protocol)
+	}
+	return e, nil
+}
+
+// Run exports the latest snapshots every interval.
+func (e *OTLPExporter) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := <-ticker.C
+
+		snapshots := latestSnapshots.Load()
+		if snapshots == nil {
+			continue
+		}
+		if err := e.Export(context.Background(), *snapshots, now); err != nil {
+			slog.Warn("Failed to export metrics with OTLP", "endpoint", e.endpoint, "error", err)
+		}
+	}
+}
+
+// Export sends one request with the snapshots.
+func (e *OTLPExporter) Export(ctx context.Context, snapshots []*ProcessSnapshot, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	req := BuildOTLPRequest(snapshots, now)
+	var partial *collectormetrics.ExportMetricsPartialSuccess
+	switch e.protocol {
+	case OTLPProtocolGRPC:
+		resp, err := e.client.Export(ctx, req)
+		if err != nil {
+			return err
+		}
+		partial = resp.GetPartialSuccess()
+	case OTLPProtocolHTTP:
+		resp, err := e.exportHTTP(ctx, req)
+		if err != nil {
+			return err
+		}
+		partial = resp.GetPartialSuccess()
+	}
+	if partial.GetRejectedDataPoints() > 0 {
+		slog.Warn("OTLP endpoint rejected data points", "rejected", partial.GetRejectedDataPoints(), "message", partial.GetErrorMessage())
+	}
+	return nil
+}
+
+func (e *OTLPExporter) exportHTTP(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+
+	httpResp, err := e.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+	}
+	resp := &collectormetrics.ExportMetricsServiceResponse{}
+	if err := proto.Unmarshal(respBody, resp); err != nil {
+		return nil, errors.Join(errors.New("failed to parse OTLP response"), err)
+	}
+	return resp, nil
+}
+
+// BuildOTLPRequest converts the snapshots to an OTLP export request with one resource per process.
+func BuildOTLPRequest(snapshots []*ProcessSnapshot, now time.Time) *collectormetrics.ExportMetricsServiceRequest {
+	timestamp := uint64(now.UnixNano())
+	req := &collectormetrics.ExportMetricsServiceRequest{}
+	for _, ps := range snapshots {
+		var metrics []*metricspb.Metric
+		for _, def := range otlpProcessMetrics {
+			metrics = append(metrics, otlpGauge(def.name, def.description, []*metricspb.NumberDataPoint{
+				otlpDataPoint(timestamp, def.value(&ps.Totals)),
+			}))
+		}
+		for _, def := range otlpMappingMetrics {
+			points := make([]*metricspb.NumberDataPoint, 0, len(ps.Mappings))
+			for _, m := range ps.Mappings {
+				point := otlpDataPoint(timestamp, def.value(m))
+				point.Attributes = []*commonpb.KeyValue{
+					otlpString("path", m.Path),
+					otlpString("category", MappingCategory(m.Path)),
+				}
+				points = append(points, point)
+			}
+			metrics = append(metrics, otlpGauge(def.name, def.description, points))
+		}
+
+		req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource: &resourcepb.Resource{Attributes: otlpResourceAttributes(ps)},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName},
+				Metrics: metrics,
+			}},
+		})
+	}
+	return req
+}
+
+// otlpResourceAttributes returns the semantic convention attributes identifying the process.
+func otlpResourceAttributes(ps *ProcessSnapshot) []*commonpb.KeyValue {
+	return []*commonpb.KeyValue{
+		otlpString("k8s.namespace.name", ps.Target.Namespace),
+		otlpString("k8s.pod.name", ps.Target.Pod),
+		otlpString("k8s.pod.uid", ps.Target.PodUID),
+		otlpString("k8s.container.name", ps.Target.Container),
+		otlpString("container.id", ps.Target.ContainerID),
+		{Key: "process.pid", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(ps.Target.PID)}}},
+		otlpString("process.executable.name", ps.Comm),
+	}
+}
+
+func otlpGauge(name, description string, points []*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name:        name,
+		Description: description,
+		Unit:        "By",
+		Data:        &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}},
+	}
+}
+
+func otlpDataPoint(timestamp uint64, value int64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		TimeUnixNano: timestamp,
+		Value:        &metricspb.NumberDataPoint_AsInt{AsInt: value},
+	}
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpTestSnapshot returns a process with a single heap mapping.
+func otlpTestSnapshot() *ProcessSnapshot {
+	return &ProcessSnapshot{
+		Target:   Target{Namespace: "default", Pod: "app-0", PodUID: "uid", Container: "app", ContainerID: "cid", PID: 42},
+		Comm:     "java",
+		Mappings: []*SmapsMapping{{Path: "[heap]", SizeBytes: 8192, RssBytes: 4096}},
+		Totals:   MemoryTotals{RssBytes: 4096},
+	}
+}
+
+// checkOTLPRequest checks the resource attributes and the mapping data points of the request.
+func checkOTLPRequest(t *testing.T, req *collectormetrics.ExportMetricsServiceRequest, now time.Time) {
+	t.Helper()
+	if len(req.GetResourceMetrics()) != 1 {
+		t.Fatalf("got %d resources, want 1", len(req.GetResourceMetrics()))
+	}
+	rm := req.GetResourceMetrics()[0]
+	attributes := make(map[string]string)
+	for _, kv := range rm.GetResource().GetAttributes() {
+		attributes[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	for key, want := range map[string]string{"k8s.namespace.name": "default", "k8s.pod.name": "app-0", "k8s.container.name": "app", "process.executable.name": "java"} {
+		if attributes[key] != want {
+			t.Errorf("resource attribute %s = %q, want %q", key, attributes[key], want)
+		}
+	}
+
+	found := false
+	for _, m := range rm.GetScopeMetrics()[0].GetMetrics() {
+		if m.GetName() != "smaps.mapping.rss" {
+			continue
+		}
+		found = true
+		points := m.GetGauge().GetDataPoints()
+		if len(points) != 1 {
+			t.Fatalf("got %d data points, want 1", len(points))
+		}
+		p := points[0]
+		if p.GetAsInt() != 4096 || p.GetTimeUnixNano() != uint64(now.UnixNano()) {
+			t.Errorf("got data point %v", p)
+		}
+		point := make(map[string]string)
+		for _, kv := range p.GetAttributes() {
+			point[kv.GetKey()] = kv.GetValue().GetStringValue()
+		}
+		if point["path"] != "[heap]" || point["category"] != CategoryHeap {
+			t.Errorf("got data point attributes %v", point)
+		}
+	}
+	if !found {
+		t.Error("smaps.mapping.rss not exported")
+	}
+}
+
+func TestOTLPExportHTTP(t *testing.T) {
+	received := make(chan *collectormetrics.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- req
+		resp, _ := proto.Marshal(&collectormetrics.ExportMetricsServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	e, err := NewOTLPExporter(OTLPProtocolHTTP, server.URL+"/v1/metrics", false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	if err := e.Export(context.Background(), []*ProcessSnapshot{otlpTestSnapshot()}, now); err != nil {
+		t.Fatal(err)
+	}
+	checkOTLPRequest(t, <-received, now)
+}
+
+func TestOTLPExportHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	e, err := NewOTLPExporter(OTLPProtocolHTTP, server.URL, false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Export(context.Background(), []*ProcessSnapshot{otlpTestSnapshot()}, time.Now()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// otlpReceiver is an in-process OTLP gRPC receiver.
+type otlpReceiver struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	received chan *collectormetrics.ExportMetricsServiceRequest
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	r.received <- req
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPExportGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := &otlpReceiver{received: make(chan *collectormetrics.ExportMetricsServiceRequest, 1)}
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, receiver)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	e, err := NewOTLPExporter(OTLPProtocolGRPC, lis.Addr().String(), true, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	if err := e.Export(context.Background(), []*ProcessSnapshot{otlpTestSnapshot()}, now); err != nil {
+		t.Fatal(err)
+	}
+	checkOTLPRequest(t, <-receiver.received, now)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Bits of a /proc/[pid]/pagemap entry, see https://docs.kernel.org/admin-guide/mm/pagemap.html
+const (
+	pagemapPFNMask   = (1 << 55) - 1
+	pagemapSoftDirty = 1 << 55
+	pagemapSwapped   = 1 << 62
+	pagemapPresent   = 1 << 63
+)
+
+// Bits of a /proc/kpageflags entry.
+const (
+	kpfDirty     = 1 << 4
+	kpfLRU       = 1 << 5
+	kpfActive    = 1 << 6
+	kpfSwapCache = 1 << 13
+	kpfKSM       = 1 << 21
+	kpfTHP       = 1 << 22
+	kpfZeroPage  = 1 << 24
+)
+
+// Page kinds reported by PageStats.
+const (
+	PageKindPresent   = "present"
+	PageKindSwapped   = "swapped"
+	PageKindTHP       = "thp"
+	PageKindKSM       = "ksm"
+	PageKindZeroPage  = "zero_page"
+	PageKindSwapCache = "swapcache"
+	PageKindDirty     = "dirty"
+	PageKindActive    = "lru_active"
+	PageKindInactive  = "lru_inactive"
+)
+
+// errPageScanBudget is returned when a PageScanner has scanned its maximum number of pages.
+var errPageScanBudget = errors.New("page scan budget exhausted")
+
+// pagemapEntrySize is the size of one entry in pagemap, kpageflags and kpagecount.
+const pagemapEntrySize = 8
+
+// PageStats classifies the pages of a mapping.
+type PageStats struct {
+	// Pages counts the pages of each kind. A page can be of several kinds.
+	Pages map[string]int64
+
+	// MapCount counts the present pages by the number of times they are mapped.
+	MapCount map[string]int64
+
+	// Truncated is true when the budget ran out before all pages of the mapping were scanned.
+	Truncated bool
+}
+
+// readPagemap returns the pagemap entries of the pages in [start, end).
+func readPagemap(f io.ReaderAt, start, end uint64, pageSize int64) ([]uint64, error) {
+	first := start / uint64(pageSize)
+	n := (end - start) / uint64(pageSize)
+	buf := make([]byte, n*pagemapEntrySize)
+	read, err := f.ReadAt(buf, int64(first*pagemapEntrySize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	entries := make([]uint64, read/pagemapEntrySize)
+	for i := range entries {
+		entries[i] = binary.LittleEndian.Uint64(buf[i*pagemapEntrySize:])
+	}
+	return entries, nil
+}
+
+// pagemapChunkEntries is the number of entries read at a time by walkPagemap, 4 KiB of pagemap.
+const pagemapChunkEntries = 512
+
+// walkPagemap reads the pagemap entries of the pages in [start, end) in chunks of
+// pagemapChunkEntries and calls fn with the address of the first page of each chunk
+// and its entries, so that large mappings are not read into memory at once.
+func walkPagemap(f io.ReaderAt, start, end uint64, pageSize int64, fn func(addr uint64, entries []uint64) error) error {
+	for addr := start; addr < end; {
+		chunkEnd := min(end, addr+pagemapChunkEntries*uint64(pageSize))
+		entries, err := readPagemap(f, addr, chunkEnd, pageSize)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		if err := fn(addr, entries); err != nil {
+			return err
+		}
+		addr = chunkEnd
+	}
+	return nil
+}
+
+// throttlePages sleeps to keep the rate of pages handled since start below pagesPerSecond.
+// A pagesPerSecond of zero disables the limit.
+func throttlePages(start time.Time, pages int64, pagesPerSecond float64) {
+	if pagesPerSecond <= 0 {
+		return
+	}
+	expected := time.Duration(float64(pages) / pagesPerSecond * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+// readPFNEntry reads the 64-bit entry of a page frame from kpageflags or kpagecount.
+func readPFNEntry(f io.ReaderAt, pfn uint64) (uint64, error) {
+	var buf [pagemapEntrySize]byte
+	if _, err := f.ReadAt(buf[:], int64(pfn*pagemapEntrySize)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// mapCountBucket groups page map counts into label values.
+func mapCountBucket(count uint64) string {
+	switch {
+	case count <= 1:
+		return strconv.FormatUint(count, 10)
+	case count <= 4:
+		return "2-4"
+	case count <= 16:
+		return "5-16"
+	default:
+		return "17+"
+	}
+}
+
+// PageScanner classifies the pages of process mappings by walking their page
+// tables through /proc/[pid]/pagemap and looking up the page frames in
+// /proc/kpageflags and /proc/kpagecount. Reading page frame numbers requires CAP_SYS_ADMIN.
+type PageScanner struct {
+	procPath string
+	pageSize int64
+	paths    *regexp.Regexp
+
+	// budget is the number of pages that may still be scanned.
+	budget int64
+}
+
+// NewPageScanner creates a scanner for the mappings whose path matches paths.
+// At most maxPages pages are scanned by a single scanner.
+func NewPageScanner(procPath string, paths *regexp.Regexp, maxPages int64) *PageScanner {
+	return &PageScanner{
+		procPath: procPath,
+		pageSize: int64(os.Getpagesize()),
+		paths:    paths,
+		budget:   maxPages,
+	}
+}
+
+// ScanProcess classifies the pages of the selected mappings of a process, keyed by path.
+// When the budget runs out, the mappings scanned so far are returned with errPageScanBudget,
+// and the ones scanned partially are marked truncated.
+func (s *PageScanner) ScanProcess(pid int, vmas []*SmapsMapping) (map[string]*PageStats, error) {
+	pagemap, err := os.Open(filepath.Join(s.procPath, strconv.Itoa(pid), "pagemap"))
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+	kpageflags, err := os.Open(filepath.Join(s.procPath, "kpageflags"))
+	if err != nil {
+		return nil, err
+	}
+	defer kpageflags.Close()
+	kpagecount, err := os.Open(filepath.Join(s.procPath, "kpagecount"))
+	if err != nil {
+		return nil, err
+	}
+	defer kpagecount.Close()
+
+	result := make(map[string]*PageStats)
+	for i, vma := range vmas {
+		if !s.paths.MatchString(vma.Path) {
+			continue
+		}
+		start, end, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		if s.budget <= 0 {
+			s.markTruncated(result, vmas[i:])
+			return result, errPageScanBudget
+		}
+
+		stats, found := result[vma.Path]
+		if !found {
+			stats = &PageStats{Pages: make(map[string]int64), MapCount: make(map[string]int64)}
+			result[vma.Path] = stats
+		}
+		err = walkPagemap(pagemap, start, end, s.pageSize, func(_ uint64, entries []uint64) error {
+			if s.budget <= 0 {
+				return errPageScanBudget
+			}
+			truncated := int64(len(entries)) > s.budget
+			entries = entries[:min(int64(len(entries)), s.budget)]
+			s.budget -= int64(len(entries))
+			for _, e := range entries {
+				s.classifyPage(e, stats, kpageflags, kpagecount)
+			}
+			if truncated {
+				return errPageScanBudget
+			}
+			return nil
+		})
+		if errors.Is(err, errPageScanBudget) {
+			s.markTruncated(result, vmas[i:])
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// markTruncated marks the mappings of the VMAs left unscanned as truncated,
+// if other VMAs of the same path were scanned.
+func (s *PageScanner) markTruncated(result map[string]*PageStats, vmas []*SmapsMapping) {
+	for _, vma := range vmas {
+		if stats, found := result[vma.Path]; found {
+			stats.Truncated = true
+		}
+	}
+}
+
+// classifyPage adds a single pagemap entry to stats.
+func (s *PageScanner) classifyPage(entry uint64, stats *PageStats, kpageflags, kpagecount io.ReaderAt) {
+	if entry&pagemapSwapped != 0 {
+		stats.Pages[PageKindSwapped]++
+		return
+	}
+	if entry&pagemapPresent == 0 {
+		return
+	}
+	stats.Pages[PageKindPresent]++
+
+	pfn := entry & pagemapPFNMask
+	if pfn == 0 {
+		// PFNs are hidden without CAP_SYS_ADMIN.
+		return
+	}
+
+	if flags, err := readPFNEntry(kpageflags, pfn); err == nil {
+		if flags&kpfTHP != 0 {
+			stats.Pages[PageKindTHP]++
+		}
+		if flags&kpfKSM != 0 {
+			stats.Pages[PageKindKSM]++
+		}
+		if flags&kpfZeroPage != 0 {
+			stats.Pages[PageKindZeroPage]++
+		}
+		if flags&kpfSwapCache != 0 {
+			stats.Pages[PageKindSwapCache]++
+		}
+		if flags&kpfDirty != 0 {
+			stats.Pages[PageKindDirty]++
+		}
+		if flags&kpfLRU != 0 {
+			if flags&kpfActive != 0 {
+				stats.Pages[PageKindActive]++
+			} else {
+				stats.Pages[PageKindInactive]++
+			}
+		}
+	}
+
+	if count, err := readPFNEntry(kpagecount, pfn); err == nil {
+		stats.MapCount[mapCountBucket(count)]++
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// writePagemap writes a synthetic pagemap with the given entries starting from the page at startPage.
+func writePagemap(t *testing.T, path string, startPage int, entries []uint64) {
+	t.Helper()
+	buf := make([]byte, (startPage+len(entries))*pagemapEntrySize)
+	for i, e := range entries {
+		binary.LittleEndian.PutUint64(buf[(startPage+i)*pagemapEntrySize:], e)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pfnTable returns a reader of a kpageflags or kpagecount file with the given entries by page frame number.
+func pfnTable(entries map[uint64]uint64) *bytes.Reader {
+	buf := make([]byte, (slices.Max(slices.Collect(maps.Keys(entries)))+1)*pagemapEntrySize)
+	for pfn, v := range entries {
+		binary.LittleEndian.PutUint64(buf[pfn*pagemapEntrySize:], v)
+	}
+	return bytes.NewReader(buf)
+}
+
+func TestClassifyPage(t *testing.T) {
+	kpageflags := pfnTable(map[uint64]uint64{
+		1: kpfLRU | kpfActive,
+		2: kpfLRU | kpfDirty,
+		3: kpfTHP | kpfLRU | kpfActive,
+		4: kpfKSM,
+		5: kpfZeroPage,
+		6: kpfSwapCache,
+	})
+	kpagecount := pfnTable(map[uint64]uint64{1: 1, 2: 3, 3: 1, 4: 20, 5: 100, 6: 0})
+
+	tests := []struct {
+		name         string
+		entry        uint64
+		wantPages    map[string]int64
+		wantMapCount map[string]int64
+	}{
+		{name: "not present", entry: 0, wantPages: map[string]int64{}, wantMapCount: map[string]int64{}},
+		{name: "swapped", entry: pagemapSwapped | 7, wantPages: map[string]int64{PageKindSwapped: 1}, wantMapCount: map[string]int64{}},
+		{
+			name:         "pfn hidden",
+			entry:        pagemapPresent,
+			wantPages:    map[string]int64{PageKindPresent: 1},
+			wantMapCount: map[string]int64{},
+		},
+		{
+			name:         "active",
+			entry:        pagemapPresent | 1,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindActive: 1},
+			wantMapCount: map[string]int64{"1": 1},
+		},
+		{
+			name:         "inactive dirty shared",
+			entry:        pagemapPresent | 2,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindInactive: 1, PageKindDirty: 1},
+			wantMapCount: map[string]int64{"2-4": 1},
+		},
+		{
+			name:         "thp",
+			entry:        pagemapPresent | pagemapSoftDirty | 3,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindTHP: 1, PageKindActive: 1},
+			wantMapCount: map[string]int64{"1": 1},
+		},
+		{
+			name:         "ksm",
+			entry:        pagemapPresent | 4,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindKSM: 1},
+			wantMapCount: map[string]int64{"17+": 1},
+		},
+		{
+			name:         "zero page",
+			entry:        pagemapPresent | 5,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindZeroPage: 1},
+			wantMapCount: map[string]int64{"17+": 1},
+		},
+		{
+			name:         "swap cache",
+			entry:        pagemapPresent | 6,
+			wantPages:    map[string]int64{PageKindPresent: 1, PageKindSwapCache: 1},
+			wantMapCount: map[string]int64{"0": 1},
+		},
+		{
+			name:         "pfn beyond kpageflags",
+			entry:        pagemapPresent | 1000,
+			wantPages:    map[string]int64{PageKindPresent: 1},
+			wantMapCount: map[string]int64{},
+		},
+	}
+
+	s := NewPageScanner(t.TempDir(), regexp.MustCompile(".*"), 1024)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &PageStats{Pages: make(map[string]int64), MapCount: make(map[string]int64)}
+			s.classifyPage(tt.entry, stats, kpageflags, kpagecount)
+			if !maps.Equal(stats.Pages, tt.wantPages) {
+				t.Errorf("got pages %v, want %v", stats.Pages, tt.wantPages)
+			}
+			if !maps.Equal(stats.MapCount, tt.wantMapCount) {
+				t.Errorf("got map counts %v, want %v", stats.MapCount, tt.wantMapCount)
+			}
+		})
+	}
+}
+
+func TestReadPagemap(t *testing.T) {
+	const pageSize = 4096
+	entries := make([]uint64, 2*pagemapChunkEntries+10)
+	for i := range entries {
+		entries[i] = pagemapPresent | uint64(i+1)
+	}
+	path := filepath.Join(t.TempDir(), "pagemap")
+	writePagemap(t, path, 0, entries)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name       string
+		start, end uint64
+		want       []uint64
+	}{
+		{name: "single page", start: 3 * pageSize, end: 4 * pageSize, want: entries[3:4]},
+		{name: "range", start: 10 * pageSize, end: 20 * pageSize, want: entries[10:20]},
+		{name: "truncated at end of file", start: uint64(len(entries)-2) * pageSize, end: uint64(len(entries)+5) * pageSize, want: entries[len(entries)-2:]},
+		{name: "beyond end of file", start: uint64(len(entries)+1) * pageSize, end: uint64(len(entries)+2) * pageSize, want: []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPagemap(f, tt.start, tt.end, pageSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %d entries %v, want %d entries", len(got), got[:min(len(got), 4)], len(tt.want))
+			}
+		})
+	}
+
+	t.Run("chunks", func(t *testing.T) {
+		var got []uint64
+		var chunks int
+		err := walkPagemap(f, 5*pageSize, uint64(len(entries))*pageSize, pageSize, func(addr uint64, chunk []uint64) error {
+			if want := uint64(5+len(got)) * pageSize; addr != want {
+				t.Errorf("chunk %d at %#x, want %#x", chunks, addr, want)
+			}
+			if len(chunk) > pagemapChunkEntries {
+				t.Errorf("chunk %d has %d entries", chunks, len(chunk))
+			}
+			got = append(got, chunk...)
+			chunks++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, entries[5:]) || chunks != 3 {
+			t.Errorf("got %d entries in %d chunks, want %d entries in 3 chunks", len(got), chunks, len(entries)-5)
+		}
+	})
+}
+
+func TestScanProcessBudget(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	procPath := t.TempDir()
+	writePagemap(t, filepath.Join(procPath, "42", "pagemap"), 16, []uint64{pagemapPresent | 1, pagemapPresent | 1, pagemapSwapped, pagemapPresent | 1})
+	for name, v := range map[string]uint64{"kpageflags": kpfLRU, "kpagecount": 1} {
+		writePagemap(t, filepath.Join(procPath, name), 1, []uint64{v})
+	}
+	vmas := []*SmapsMapping{
+		{AddrRange: fmt.Sprintf("%x-%x", 16*pageSize, 20*pageSize), Path: "[heap]"},
+		{AddrRange: fmt.Sprintf("%x-%x", 20*pageSize, 21*pageSize), Path: "[stack]"},
+	}
+
+	// The budget ends in the middle of the heap.
+	s := NewPageScanner(procPath, regexp.MustCompile(`^\[(heap|stack)\]$`), 3)
+	result, err := s.ScanProcess(42, vmas)
+	if !errors.Is(err, errPageScanBudget) {
+		t.Errorf("got error %v, want %v", err, errPageScanBudget)
+	}
+	want := map[string]int64{PageKindPresent: 2, PageKindSwapped: 1, PageKindInactive: 2}
+	if heap := result["[heap]"]; heap == nil || !maps.Equal(heap.Pages, want) || !heap.Truncated {
+		t.Errorf("got heap pages %+v, want %v truncated", heap, want)
+	}
+	if _, found := result["[stack]"]; found {
+		t.Error("stack scanned beyond the budget")
+	}
+}
+
+func TestScanPagesRotates(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	procPath := t.TempDir()
+	for name, v := range map[string]uint64{"kpageflags": kpfLRU, "kpagecount": 1} {
+		writePagemap(t, filepath.Join(procPath, name), 1, []uint64{v})
+	}
+	// Three processes with a heap of two pages each, and a budget of two pages.
+	var snapshots []*ProcessSnapshot
+	for _, pid := range []int{1, 2, 3} {
+		writePagemap(t, filepath.Join(procPath, fmt.Sprint(pid), "pagemap"), 16, []uint64{pagemapPresent | 1, pagemapPresent | 1})
+		snapshots = append(snapshots, &ProcessSnapshot{
+			Target: Target{PID: pid},
+			VMAs:   []*SmapsMapping{{AddrRange: fmt.Sprintf("%x-%x", 16*pageSize, 18*pageSize), Path: "[heap]"}},
+		})
+	}
+
+	first := 0
+	for poll, want := range []int{1, 2, 3, 1} {
+		for _, ps := range snapshots {
+			ps.PageStats = nil
+		}
+		first = scanPages(snapshots, NewPageScanner(procPath, regexp.MustCompile(`^\[heap\]$`), 2), first)
+		var scanned []int
+		for _, ps := range snapshots {
+			if heap := ps.PageStats["[heap]"]; heap != nil && !heap.Truncated && heap.Pages[PageKindPresent] == 2 {
+				scanned = append(scanned, ps.Target.PID)
+			}
+		}
+		if !slices.Equal(scanned, []int{want}) {
+			t.Errorf("poll %d: scanned %v, want [%d]", poll, scanned, want)
+		}
+	}
+}
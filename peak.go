@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// peakWindow holds the values a series has had since the last scrape.
+type peakWindow struct {
+	name        string
+	labelNames  []string
+	labelValues []string
+
+	min, max, sum float64
+	count         int
+	last          float64
+	present       bool
+}
+
+// reset starts a new window that begins with the last observed value.
+func (w *peakWindow) reset() {
+	w.min, w.max, w.sum, w.count = w.last, w.last, w.last, 1
+}
+
+// PeakTracker records the minimum, maximum and average of gauges between scrapes.
+//
+// The exporter polls more often than Prometheus scrapes, so short spikes are
+// lost when only the current value is exported. Observe is called after each
+// poll and each scrape exports the window as <name>_window_max,
+// <name>_window_min and <name>_window_avg before starting a new window. When
+// several Prometheus servers scrape the exporter, each of them resets the
+// window of the others, so the tracker must not be registered where anything
+// else gathers it, e.g. remote write.
+type PeakTracker struct {
+	gatherer prometheus.Gatherer
+	names    *regexp.Regexp
+
+	mu      sync.Mutex
+	windows map[string]*peakWindow
+	descs   map[string]*prometheus.Desc
+}
+
+// NewPeakTracker creates a tracker for the gauges gathered from gatherer whose name matches names.
+func NewPeakTracker(names *regexp.Regexp, gatherer prometheus.Gatherer) *PeakTracker {
+	return &PeakTracker{
+		gatherer: gatherer,
+		names:    names,
+		windows:  make(map[string]*peakWindow),
+		descs:    make(map[string]*prometheus.Desc),
+	}
+}
+
+// Observe adds the current value of each tracked series to its window.
+func (p *PeakTracker) Observe() {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		slog.Warn("Failed to gather metrics for peak tracking", "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, w := range p.windows {
+		w.present = false
+	}
+	for _, mf := range families {
+		if mf.GetType() != dto.MetricType_GAUGE || !p.names.MatchString(mf.GetName()) {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			p.observe(mf.GetName(), m.GetLabel(), m.GetGauge().GetValue())
+		}
+	}
+}
+
+func (p *PeakTracker) observe(name string, labels []*dto.LabelPair, value float64) {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, l := range labels {
+		key.WriteByte(0xff)
+		key.WriteString(l.GetValue())
+	}
+
+	w, ok := p.windows[key.String()]
+	if !ok {
+		w = &peakWindow{name: name}
+		for _, l := range labels {
+			w.labelNames = append(w.labelNames, l.GetName())
+			w.labelValues = append(w.labelValues, l.GetValue())
+		}
+		p.windows[key.String()] = w
+	}
+
+	if w.count == 0 {
+		w.min, w.max = value, value
+	}
+	w.min = min(w.min, value)
+	w.max = max(w.max, value)
+	w.sum += value
+	w.count++
+	w.last = value
+	w.present = true
+}
+
+// Describe sends no descriptors, which makes the tracker an unchecked collector
+// since the tracked series are only known after the first poll.
+func (p *PeakTracker) Describe(chan<- *prometheus.Desc) {}
+
+// Collect exports the window of each series and starts a new one.
+func (p *PeakTracker) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, w := range p.windows {
+		if w.count > 0 {
+			ch <- prometheus.MustNewConstMetric(p.desc(w, "max"), prometheus.GaugeValue, w.max, w.labelValues...)
+			ch <- prometheus.MustNewConstMetric(p.desc(w, "min"), prometheus.GaugeValue, w.min, w.labelValues...)
+			ch <- prometheus.MustNewConstMetric(p.desc(w, "avg"), prometheus.GaugeValue, w.sum/float64(w.count), w.labelValues...)
+		}
+
+		// Series that disappeared are exported one last time, so that a spike
+		// of a process that exited before the scrape is not lost.
+		if !w.present {
+			delete(p.windows, key)
+			continue
+		}
+		w.reset()
+	}
+}
+
+func (p *PeakTracker) desc(w *peakWindow, stat string) *prometheus.Desc {
+	name := w.name + "_window_" + stat
+	key := name + "\xff" + strings.Join(w.labelNames, "\xff")
+	d, ok := p.descs[key]
+	if !ok {
+		d = prometheus.NewDesc(name, "The "+peakStatHelp[stat]+" of "+w.name+" since the previous scrape.", w.labelNames, nil)
+		p.descs[key] = d
+	}
+	return d
+}
+
+var peakStatHelp = map[string]string{
+	"max": "maximum",
+	"min": "minimum",
+	"avg": "average",
+}
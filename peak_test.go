@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPeakTrackerTracksGatheredGauges(t *testing.T) {
+	source := prometheus.NewRegistry()
+	rss := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "process_smaps_rss_bytes"}, []string{"comm", "path"})
+	pss := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "process_smaps_pss_bytes"}, []string{"comm", "path"})
+	source.MustRegister(rss, pss)
+
+	peaks := NewPeakTracker(regexp.MustCompile(`^process_smaps_rss_bytes$`), source)
+	for _, v := range []float64{10, 30, 20} {
+		rss.WithLabelValues("java", "[heap]").Set(v)
+		pss.WithLabelValues("java", "[heap]").Set(v)
+		peaks.Observe()
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(peaks)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			got[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	want := map[string]float64{
+		"process_smaps_rss_bytes_window_max": 30,
+		"process_smaps_rss_bytes_window_min": 10,
+		"process_smaps_rss_bytes_window_avg": 20,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got series %v, want %v", got, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// pprofSampleTypes lists the values of each sample in the memory profile.
+var pprofSampleTypes = []struct {
+	name  string
+	value func(m *SmapsMapping) int64
+}{
+	{"rss", func(m *SmapsMapping) int64 { return m.RssBytes }},
+	{"pss", func(m *SmapsMapping) int64 { return m.PssBytes }},
+	{"private_dirty", func(m *SmapsMapping) int64 { return m.PrivateDirtyBytes }},
+	{"swap", func(m *SmapsMapping) int64 { return m.SwapBytes }},
+}
+
+// BuildMemoryProfile renders the mappings of the processes as a pprof profile.
+//
+// Each mapping is a sample whose stack is, from the root, the container, the
+// process, the mapping category and the path, so that a flame graph shows
+// where the memory of a container goes and the top view ranks paths.
+func BuildMemoryProfile(snapshots []*ProcessSnapshot, now time.Time) *profile.Profile {
+	p := &profile.Profile{
+		TimeNanos:         now.UnixNano(),
+		DefaultSampleType: "pss",
+	}
+	for _, st := range pprofSampleTypes {
+		p.SampleType = append(p.SampleType, &profile.ValueType{Type: st.name, Unit: "bytes"})
+	}
+
+	locations := make(map[string]*profile.Location)
+	location := func(name string) *profile.Location {
+		if loc, ok := locations[name]; ok {
+			return loc
+		}
+		fn := &profile.Function{ID: uint64(len(p.Function) + 1), Name: name, SystemName: name}
+		loc := &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		locations[name] = loc
+		return loc
+	}
+
+	for _, ps := range snapshots {
+		ref := ps.Target.ContainerRef()
+		container := ref.Namespace + "/" + ref.Pod + "/" + ref.Container
+		process := fmt.Sprintf("%s (%d)", ps.Comm, ps.Target.PID)
+		for _, m := range ps.Mappings {
+			category := MappingCategory(m.Path)
+			values := make([]int64, len(pprofSampleTypes))
+			for i, st := range pprofSampleTypes {
+				values[i] = st.value(m)
+			}
+			p.Sample = append(p.Sample, &profile.Sample{
+				// Locations are ordered from the leaf to the root.
+				Location: []*profile.Location{
+					location(m.Path),
+					location(category),
+					location(process),
+					location(container),
+				},
+				Value:    values,
+				Label:    map[string][]string{"category": {category}, "comm": {ps.Comm}},
+				NumLabel: map[string][]int64{"pid": {int64(ps.Target.PID)}},
+			})
+		}
+	}
+	return p
+}
+
+// pprofHandler serves /api/v1/pprof/{namespace}/{pod}/{container} with a memory
+// profile of the live mappings of the matching processes. The optional command
+// and pid query parameters select processes within the container. Only processes
+// within the monitored scope are profiled.
+func pprofHandler(finder Finder, scope ProcessFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, pid, err := requestProcessFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snapshots, err := collectScopedProcesses(finder, scope, filter, pid)
+		if err != nil {
+			http.Error(w, err.Error(), targetsErrorStatus(err))
+			return
+		}
+		if len(snapshots) == 0 {
+			http.Error(w, ErrNoTargets.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="smaps.pb.gz"`)
+		if err := BuildMemoryProfile(snapshots, time.Now()).Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
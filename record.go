@@ -0,0 +1,329 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RecordVersion is the version of the archive format written by the record command.
+// It is incremented whenever a change would make older replays misinterpret the archive.
+const RecordVersion = 1
+
+// recordProcessFiles lists the files copied from /proc/<pid> of each target.
+// Optional files are not provided by every kernel.
+var recordProcessFiles = []struct {
+	name     string
+	optional bool
+}{
+	{"smaps", false},
+	{"status", false},
+	{"statm", false},
+	{"comm", false},
+	{"cmdline", false},
+	{"cgroup", false},
+	{"ksm_stat", true},
+	{"ksm_merging_pages", true},
+	{"numa_maps", true},
+}
+
+// recordSystemFiles lists the files copied from /proc that are not specific to a process.
+var recordSystemFiles = []string{"sys/kernel/osrelease", "sys/vm/max_map_count"}
+
+// recordCgroupFiles lists the files copied from the cgroup directory of each container.
+var recordCgroupFiles = []string{"memory.current", "memory.max", "memory.high", "memory.min", "memory.stat", "memory.events"}
+
+// RecordHeader describes a recording. It is the first entry of the archive, record.json.
+type RecordHeader struct {
+	Version       int       `json:"version"`
+	Time          time.Time `json:"time"`
+	Hostname      string    `json:"hostname"`
+	KernelRelease string    `json:"kernel_release"`
+	Selector      string    `json:"selector"`
+}
+
+// RecordFrame holds the runtime metadata captured in one recording cycle. It is
+// stored as frames/<n>/frame.json, after the proc and cgroup files of the cycle
+// under frames/<n>/proc and frames/<n>/cgroup.
+type RecordFrame struct {
+	Time               time.Time                      `json:"time"`
+	Targets            []Target                       `json:"targets"`
+	PodAnnotations     map[string]map[string]string   `json:"pod_annotations"`
+	ContainerResources map[string]*ContainerResources `json:"container_resources"`
+}
+
+// recordFrameDir returns the directory of a frame in the archive.
+func recordFrameDir(n int) string {
+	return fmt.Sprintf("frames/%06d", n)
+}
+
+// Recorder writes the proc and cgroup files of the targets and their runtime
+// metadata to a gzip compressed tar archive, one frame per call to Record.
+type Recorder struct {
+	finder Finder
+	filter ProcessFilter
+	zw     *gzip.Writer
+	tw     *tar.Writer
+	frames int
+}
+
+// NewRecorder writes the header of a recording to w.
+func NewRecorder(w io.Writer, finder Finder, filter ProcessFilter, header *RecordHeader) (*Recorder, error) {
+	zw := gzip.NewWriter(w)
+	r := &Recorder{finder: finder, filter: filter, zw: zw, tw: tar.NewWriter(zw)}
+	if err := r.writeJSON("record.json", header, header.Time); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Frames returns the number of frames recorded so far.
+func (r *Recorder) Frames() int {
+	return r.frames
+}
+
+// Record captures a frame of the processes matching the filter.
+// Processes that exit while being read are left out of the frame.
+func (r *Recorder) Record(now time.Time) (*RecordFrame, error) {
+	targets, err := r.finder.GetTargets(r.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := recordFrameDir(r.frames)
+	frame := &RecordFrame{
+		Time:               now,
+		Targets:            []Target{},
+		PodAnnotations:     make(map[string]map[string]string),
+		ContainerResources: make(map[string]*ContainerResources),
+	}
+	for _, name := range recordSystemFiles {
+		data, err := os.ReadFile(filepath.Join(*procPath, name))
+		if err != nil {
+			slog.Debug("Failed to read system file", "name", name, "error", err)
+			continue
+		}
+		if err := r.writeFile(path.Join(dir, "proc", name), data, now); err != nil {
+			return nil, err
+		}
+	}
+
+	cgroupsRecorded := make(map[string]bool)
+	for _, t := range targets {
+		files, err := readRecordProcessFiles(t.PID)
+		if err != nil {
+			slog.Debug("Failed to read process, leaving it out of the recording", "pid", t.PID, "error", err)
+			continue
+		}
+		pidDir := path.Join(dir, "proc", strconv.Itoa(t.PID))
+		for _, f := range recordProcessFiles {
+			if data, found := files[f.name]; found {
+				if err := r.writeFile(path.Join(pidDir, f.name), data, now); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if link, err := os.Readlink(filepath.Join(*procPath, strconv.Itoa(t.PID), "ns", "pid")); err == nil {
+			if err := r.writeSymlink(path.Join(pidDir, "ns", "pid"), link, now); err != nil {
+				return nil, err
+			}
+		}
+		frame.Targets = append(frame.Targets, t)
+
+		if annotations := r.finder.PodAnnotations(t.PodUID); annotations != nil {
+			frame.PodAnnotations[t.PodUID] = annotations
+		}
+		if cgroupsRecorded[t.ContainerID] {
+			continue
+		}
+		cgroupsRecorded[t.ContainerID] = true
+		if res, err := r.finder.GetContainerResources(t.ContainerID); err != nil {
+			slog.Debug("Failed to get container resources", "containerID", t.ContainerID, "error", err)
+		} else {
+			frame.ContainerResources[t.ContainerID] = res
+		}
+		if err := r.recordCgroup(path.Join(dir, "cgroup"), t, now); err != nil {
+			slog.Debug("Failed to record container cgroup", "containerID", t.ContainerID, "error", err)
+		}
+	}
+
+	if err := r.writeJSON(path.Join(dir, "frame.json"), frame, now); err != nil {
+		return nil, err
+	}
+	r.frames++
+	return frame, nil
+}
+
+// readRecordProcessFiles reads the files of a process listed in recordProcessFiles.
+func readRecordProcessFiles(pid int) (map[string][]byte, error) {
+	pidPath := filepath.Join(*procPath, strconv.Itoa(pid))
+	files := make(map[string][]byte)
+	for _, f := range recordProcessFiles {
+		data, err := os.ReadFile(filepath.Join(pidPath, f.name))
+		if f.optional && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[f.name] = data
+	}
+	return files, nil
+}
+
+// recordCgroup copies the memory files of the cgroup of the target's container,
+// keeping their path relative to the cgroup hierarchy.
+func (r *Recorder) recordCgroup(dir string, t Target, now time.Time) error {
+	cgroupDir, err := findContainerCgroupDir(t)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(*cgroupPath, cgroupDir)
+	if err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("cgroup %s is outside of %s", cgroupDir, *cgroupPath)
+	}
+	for _, name := range recordCgroupFiles {
+		data, err := os.ReadFile(filepath.Join(cgroupDir, name))
+		if err != nil {
+			return err
+		}
+		if err := r.writeFile(path.Join(dir, filepath.ToSlash(rel), name), data, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) writeFile(name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}
+	if err := r.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := r.tw.Write(data)
+	return err
+}
+
+func (r *Recorder) writeSymlink(name, target string, modTime time.Time) error {
+	return r.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0o777, ModTime: modTime})
+}
+
+func (r *Recorder) writeJSON(name string, v any, modTime time.Time) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.writeFile(name, data, modTime)
+}
+
+// Close finishes the archive. It does not close the underlying writer.
+func (r *Recorder) Close() error {
+	if err := r.tw.Close(); err != nil {
+		return err
+	}
+	return r.zw.Close()
+}
+
+// recordCommand implements the record subcommand, which archives the proc and
+// cgroup files of the selected processes over time for the replay mode.
+func recordCommand(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	output := fs.String("o", "", "File to write the recording to (default smaps-record-<time>.tar.gz)")
+	interval := fs.Duration("interval", 10*time.Second, "Interval between recorded frames")
+	duration := fs.Duration("duration", 5*time.Minute, "Time to record for, until interrupted when 0")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] record [record flags] [namespace/pod/container/command]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Records the selected processes, by default those selected by -filter, for replay with -replay.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 || *interval <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	selector := *processFilter
+	if fs.NArg() == 1 {
+		selector = fs.Arg(0)
+	}
+	filter, err := ParseProcessFilter(selector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid selector, expected namespace/pod/container/command:", err)
+		return 2
+	}
+
+	finder, err := newFinder()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	start := time.Now()
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = "smaps-record-" + start.UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create recording:", err)
+		return 1
+	}
+	defer f.Close()
+
+	header := &RecordHeader{Version: RecordVersion, Time: start, KernelRelease: readKernelRelease(), Selector: selector}
+	header.Hostname, _ = os.Hostname()
+	recorder, err := NewRecorder(f, finder, filter, header)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write recording:", err)
+		return 1
+	}
+
+	// Stop cleanly on interrupt so that the archive is complete.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for now := start; ctx.Err() == nil; {
+		frame, err := recorder.Record(now)
+		if err != nil {
+			slog.Error("Failed to record frame", "error", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Recorded frame %d with %d processes\n", recorder.Frames(), len(frame.Targets))
+		}
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+
+	if err := recorder.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write recording:", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write recording:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d frames to %s\n", recorder.Frames(), outputPath)
+	return 0
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Backoff between retries of a failed remote write request.
+const (
+	remoteWriteMinBackoff = 1 * time.Second
+	remoteWriteMaxBackoff = 30 * time.Second
+)
+
+// remoteLabel is a label of a remote write series.
+type remoteLabel struct {
+	name, value string
+}
+
+// remoteSample is a sample of one series queued for remote write.
+type remoteSample struct {
+	labels    []remoteLabel
+	value     float64
+	timestamp int64
+}
+
+// errRemoteWriteRetryable marks failures after which the request is sent again.
+var errRemoteWriteRetryable = errors.New("retryable remote write failure")
+
+// RemoteWriter pushes the samples of a gatherer with the Prometheus remote write protocol.
+//
+// Every interval the gatherer is collected and its samples are appended to a
+// bounded in-memory queue, from which the oldest samples are dropped when the
+// endpoint cannot keep up. A separate goroutine sends the queue in batches as
+// snappy compressed protobuf WriteRequests, retrying server errors with
+// exponential backoff. See https://prometheus.io/docs/specs/prw/remote_write_spec/
+type RemoteWriter struct {
+	url            string
+	gatherer       prometheus.Gatherer
+	externalLabels []remoteLabel
+	queueSize      int
+	batchSize      int
+	maxRetries     int
+	client         *http.Client
+
+	mu     sync.Mutex
+	queue  []remoteSample
+	notify chan struct{}
+}
+
+// NewRemoteWriter creates a writer that sends the samples of gatherer to url.
+// The external labels are added to every series that does not already have them.
+func NewRemoteWriter(url string, gatherer prometheus.Gatherer, externalLabels map[string]string, queueSize, batchSize, maxRetries int, timeout time.Duration) *RemoteWriter {
+	r := &RemoteWriter{
+		url:        url,
+		gatherer:   gatherer,
+		queueSize:  queueSize,
+		batchSize:  batchSize,
+		maxRetries: maxRetries,
+		client:     &http.Client{Timeout: timeout},
+		notify:     make(chan struct{}, 1),
+	}
+	for name, value := range externalLabels {
+		r.externalLabels = append(r.externalLabels, remoteLabel{name: name, value: value})
+	}
+	return r
+}
+
+// labelNameRe matches the label names accepted by Prometheus.
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ParseExternalLabels parses labels in the format name=value,name=value.
+// Names must be valid Prometheus label names, and names starting with __ are
+// reserved for internal use.
+func ParseExternalLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	for pair := range strings.SplitSeq(s, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid external label %q, expected name=value", pair)
+		}
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid external label name %q", name)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// Run gathers the samples every interval and sends them in the background.
+func (r *RemoteWriter) Run(interval time.Duration) {
+	go r.send()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := <-ticker.C
+
+		families, err := r.gatherer.Gather()
+		if err != nil {
+			slog.Warn("Failed to gather metrics for remote write", "error", err)
+		}
+		r.enqueue(remoteSamples(families, r.externalLabels, now.UnixMilli()))
+	}
+}
+
+// enqueue appends samples to the queue, dropping the oldest samples when it is full.
+func (r *RemoteWriter) enqueue(samples []remoteSample) {
+	r.mu.Lock()
+	r.queue = append(r.queue, samples...)
+	if overflow := len(r.queue) - r.queueSize; overflow > 0 {
+		slog.Warn("Remote write queue is full, dropping oldest samples", "dropped", overflow)
+		r.queue = slices.Clone(r.queue[overflow:])
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns up to batchSize samples from the head of the queue.
+func (r *RemoteWriter) take() []remoteSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := min(len(r.queue), r.batchSize)
+	batch := r.queue[:n:n]
+	r.queue = r.queue[n:]
+	return batch
+}
+
+// send writes the queued samples whenever new samples are enqueued.
+func (r *RemoteWriter) send() {
+	for range r.notify {
+		for batch := r.take(); len(batch) > 0; batch = r.take() {
+			if err := r.writeWithRetries(batch); err != nil {
+				slog.Warn("Failed to remote write samples, dropping them", "url", r.url, "samples", len(batch), "error", err)
+			}
+		}
+	}
+}
+
+func (r *RemoteWriter) writeWithRetries(batch []remoteSample) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := remoteWriteMinBackoff
+	for attempt := 0; ; attempt++ {
+		err := r.write(body)
+		if err == nil || !errors.Is(err, errRemoteWriteRetryable) || attempt >= r.maxRetries {
+			return err
+		}
+		slog.Debug("Retrying remote write", "url", r.url, "attempt", attempt+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, remoteWriteMaxBackoff)
+	}
+}
+
+func (r *RemoteWriter) write(body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "smaps-container-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Join(errRemoteWriteRetryable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return errors.Join(errRemoteWriteRetryable, err)
+	}
+	return err
+}
+
+// remoteSamples converts gathered metric families to samples, expanding
+// summaries and histograms into their series as Prometheus does when scraping.
+func remoteSamples(families []*dto.MetricFamily, externalLabels []remoteLabel, timestamp int64) []remoteSample {
+	var samples []remoteSample
+	add := func(name string, pairs []*dto.LabelPair, value float64, extra ...remoteLabel) {
+		labels := make([]remoteLabel, 0, 1+len(pairs)+len(extra)+len(externalLabels))
+		labels = append(labels, remoteLabel{name: "__name__", value: name})
+		for _, p := range pairs {
+			labels = append(labels, remoteLabel{name: p.GetName(), value: p.GetValue()})
+		}
+		labels = append(labels, extra...)
+		for _, l := range externalLabels {
+			if !slices.ContainsFunc(labels, func(o remoteLabel) bool { return o.name == l.name }) {
+				labels = append(labels, l)
+			}
+		}
+		slices.SortFunc(labels, func(a, b remoteLabel) int { return strings.Compare(a.name, b.name) })
+		samples = append(samples, remoteSample{labels: labels, value: value, timestamp: timestamp})
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				add(name, m.GetLabel(), m.GetGauge().GetValue())
+			case dto.MetricType_COUNTER:
+				add(name, m.GetLabel(), m.GetCounter().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetLabel(), m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, m.GetLabel(), q.GetValue(), remoteLabel{name: "quantile", value: formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", m.GetLabel(), s.GetSampleSum())
+				add(name+"_count", m.GetLabel(), float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add(name+"_bucket", m.GetLabel(), float64(b.GetCumulativeCount()), remoteLabel{name: "le", value: formatFloat(b.GetUpperBound())})
+				}
+				add(name+"_bucket", m.GetLabel(), float64(h.GetSampleCount()), remoteLabel{name: "le", value: "+Inf"})
+				add(name+"_sum", m.GetLabel(), h.GetSampleSum())
+				add(name+"_count", m.GetLabel(), float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the samples as a prometheus.WriteRequest protobuf message,
+// with one TimeSeries per sample.
+func encodeWriteRequest(samples []remoteSample) []byte {
+	var req, series, label, sample []byte
+	for _, s := range samples {
+		series = series[:0]
+		for _, l := range s.labels {
+			label = label[:0]
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		sample = sample[:0]
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a prometheus.WriteRequest protobuf message into samples.
+func decodeWriteRequest(t *testing.T, b []byte) []remoteSample {
+	t.Helper()
+	// fields calls fn with the number and value of each field of a message.
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeBytes(b)
+				if n < 0 {
+					t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+				}
+				fn(num, typ, v, 0)
+				b = b[n:]
+			case protowire.Fixed64Type:
+				v, n := protowire.ConsumeFixed64(b)
+				if n < 0 {
+					t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+				}
+				fn(num, typ, nil, v)
+				b = b[n:]
+			case protowire.VarintType:
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+				}
+				fn(num, typ, nil, v)
+				b = b[n:]
+			default:
+				t.Fatalf("unexpected wire type %d of field %d", typ, num)
+			}
+		}
+	}
+
+	var samples []remoteSample
+	fields(b, func(num protowire.Number, _ protowire.Type, series []byte, _ uint64) {
+		if num != 1 {
+			t.Fatalf("unexpected WriteRequest field %d", num)
+		}
+		var s remoteSample
+		fields(series, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var l remoteLabel
+				fields(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+					switch num {
+					case 1:
+						l.name = string(v)
+					case 2:
+						l.value = string(v)
+					}
+				})
+				s.labels = append(s.labels, l)
+			case 2:
+				fields(v, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) {
+					switch num {
+					case 1:
+						s.value = math.Float64frombits(n)
+					case 2:
+						s.timestamp = int64(n)
+					}
+				})
+			}
+		})
+		samples = append(samples, s)
+	})
+	return samples
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rss := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "container_smaps_rss_bytes"}, []string{"namespace", "pod", "container"})
+	registry.MustRegister(rss)
+	rss.WithLabelValues("default", "app-0", "app").Set(4096)
+	rss.WithLabelValues("default", "app-1", "app").Set(1.5)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := []remoteLabel{{name: "cluster", value: "edge-1"}, {name: "pod", value: "ignored"}}
+	samples := remoteSamples(families, external, 1700000000000)
+
+	want := []remoteSample{
+		{
+			labels: []remoteLabel{
+				{"__name__", "container_smaps_rss_bytes"},
+				{"cluster", "edge-1"},
+				{"container", "app"},
+				{"namespace", "default"},
+				{"pod", "app-0"},
+			},
+			value:     4096,
+			timestamp: 1700000000000,
+		},
+		{
+			labels: []remoteLabel{
+				{"__name__", "container_smaps_rss_bytes"},
+				{"cluster", "edge-1"},
+				{"container", "app"},
+				{"namespace", "default"},
+				{"pod", "app-1"},
+			},
+			value:     1.5,
+			timestamp: 1700000000000,
+		},
+	}
+	got := decodeWriteRequest(t, encodeWriteRequest(samples))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoteWriterWrite(t *testing.T) {
+	received := make(chan []remoteSample, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unexpected headers", http.StatusBadRequest)
+			return
+		}
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- decodeWriteRequest(t, body)
+	}))
+	defer server.Close()
+
+	w := NewRemoteWriter(server.URL, prometheus.NewRegistry(), map[string]string{"cluster": "edge-1"}, 10, 10, 0, time.Second)
+	batch := []remoteSample{{labels: []remoteLabel{{"__name__", "up"}, {"cluster", "edge-1"}}, value: 1, timestamp: 1000}}
+	if err := w.writeWithRetries(batch); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !reflect.DeepEqual(got, batch) {
+		t.Errorf("received %+v, want %+v", got, batch)
+	}
+}
+
+func TestRemoteWriterClientErrorIsNotRetried(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	w := NewRemoteWriter(server.URL, prometheus.NewRegistry(), nil, 10, 10, 3, time.Second)
+	if err := w.writeWithRetries([]remoteSample{{labels: []remoteLabel{{"__name__", "up"}}, value: 1}}); err == nil {
+		t.Error("expected an error")
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
+
+func TestParseExternalLabels(t *testing.T) {
+	labels, err := ParseExternalLabels(" cluster = prod ,region=eu-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"cluster": "prod", "region": "eu-1"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got %v, want %v", labels, want)
+	}
+
+	for _, s := range []string{"cluster", " =prod", "cluster=prod,", "1cluster=prod", "clus-ter=prod", "__name__=up"} {
+		if _, err := ParseExternalLabels(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReplayFinder replays an archive written by the record command in place of the
+// container runtime. The archive is extracted to a temporary directory, and a
+// symbolic link named current points at the proc and cgroup files of the frame
+// being replayed, so that -proc-path and -cgroup-path stay valid while the
+// replay advances through the frames.
+type ReplayFinder struct {
+	dir       string
+	speed     float64
+	frames    []*RecordFrame
+	frameDirs []string
+	current   atomic.Pointer[RecordFrame]
+}
+
+// OpenReplay extracts a recording and selects its first frame. Speed scales the
+// time between frames, so that 2 replays the recording twice as fast.
+func OpenReplay(archive string, speed float64) (*ReplayFinder, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed %v", speed)
+	}
+	dir, err := os.MkdirTemp("", "smaps-replay-")
+	if err != nil {
+		return nil, err
+	}
+	r := &ReplayFinder{dir: dir, speed: speed}
+	header, err := r.extract(archive)
+	if err == nil {
+		err = r.setFrame(0)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to open recording %s: %w", archive, err)
+	}
+	slog.Info("Replaying recording", "archive", archive, "hostname", header.Hostname, "kernelRelease", header.KernelRelease,
+		"selector", header.Selector, "frames", len(r.frames), "start", r.frames[0].Time, "end", r.frames[len(r.frames)-1].Time)
+	return r, nil
+}
+
+// extract unpacks the archive into the directory of the replay and reads the header and frames.
+func (r *ReplayFinder) extract(archive string) (*RecordHeader, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	// The root keeps symbolic links in the archive from pointing files outside of the directory.
+	root, err := os.OpenRoot(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	var header *RecordHeader
+	frames := make(map[int]*RecordFrame)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return nil, fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+
+		switch {
+		case hdr.Name == "record.json":
+			header = &RecordHeader{}
+			if err := json.NewDecoder(tr).Decode(header); err != nil {
+				return nil, fmt.Errorf("invalid header: %w", err)
+			}
+			if header.Version < 1 || header.Version > RecordVersion {
+				return nil, fmt.Errorf("unsupported recording version %d, expected at most %d", header.Version, RecordVersion)
+			}
+		case path.Base(hdr.Name) == "frame.json":
+			n, err := strconv.Atoi(strings.TrimPrefix(path.Dir(hdr.Name), "frames/"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid frame %q in archive", hdr.Name)
+			}
+			frame := &RecordFrame{}
+			if err := json.NewDecoder(tr).Decode(frame); err != nil {
+				return nil, fmt.Errorf("invalid frame %d: %w", n, err)
+			}
+			frames[n] = frame
+		case hdr.Typeflag == tar.TypeReg:
+			if err := root.MkdirAll(path.Dir(hdr.Name), 0o755); err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if err := root.WriteFile(hdr.Name, data, 0o644); err != nil {
+				return nil, err
+			}
+		case hdr.Typeflag == tar.TypeSymlink:
+			if err := root.MkdirAll(path.Dir(hdr.Name), 0o755); err != nil {
+				return nil, err
+			}
+			if err := root.Symlink(hdr.Linkname, hdr.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if header == nil {
+		return nil, fmt.Errorf("not a recording, record.json not found")
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("recording has no frames")
+	}
+
+	for _, n := range slices.Sorted(maps.Keys(frames)) {
+		dir := recordFrameDir(n)
+		// Frames without processes have no files.
+		for _, sub := range []string{"proc", "cgroup"} {
+			if err := root.MkdirAll(path.Join(dir, sub), 0o755); err != nil {
+				return nil, err
+			}
+		}
+		r.frames = append(r.frames, frames[n])
+		r.frameDirs = append(r.frameDirs, dir)
+	}
+	return header, nil
+}
+
+// ProcPath returns the path to use as -proc-path during the replay.
+func (r *ReplayFinder) ProcPath() string {
+	return filepath.Join(r.dir, "current", "proc")
+}
+
+// CgroupPath returns the path to use as -cgroup-path during the replay.
+func (r *ReplayFinder) CgroupPath() string {
+	return filepath.Join(r.dir, "current", "cgroup")
+}
+
+// setFrame replaces the current link to point at the given frame.
+func (r *ReplayFinder) setFrame(i int) error {
+	link := filepath.Join(r.dir, "current")
+	tmp := link + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Symlink(r.frameDirs[i], tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		return err
+	}
+	r.current.Store(r.frames[i])
+	return nil
+}
+
+// Run advances through the frames at the recorded pace, scaled by the replay
+// speed. The last frame is kept when the end of the recording is reached.
+func (r *ReplayFinder) Run() {
+	for i := 1; i < len(r.frames); i++ {
+		time.Sleep(time.Duration(float64(r.frames[i].Time.Sub(r.frames[i-1].Time)) / r.speed))
+		if err := r.setFrame(i); err != nil {
+			slog.Error("Failed to advance replay", "frame", i, "error", err)
+			return
+		}
+		slog.Debug("Replaying frame", "frame", i, "time", r.frames[i].Time)
+	}
+	slog.Info("Replay reached the end of the recording", "time", r.frames[len(r.frames)-1].Time)
+}
+
+// Close removes the extracted recording.
+func (r *ReplayFinder) Close() error {
+	return os.RemoveAll(r.dir)
+}
+
+// GetTargets returns the recorded processes of the current frame matching the given filter.
+func (r *ReplayFinder) GetTargets(filter ProcessFilter) ([]Target, error) {
+	var targets []Target
+	for _, t := range r.current.Load().Targets {
+		// Only read comm when the filter needs it.
+		var comm string
+		if filter.Command != "*" {
+			var err error
+			if comm, err = findComm(t.PID); err != nil {
+				continue
+			}
+		}
+		if filter.Matches(t, comm) {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: no recorded processes match the filter %s", ErrNoTargets, filter)
+	}
+	return targets, nil
+}
+
+// PodAnnotations returns the recorded annotations of a pod in the current frame.
+func (r *ReplayFinder) PodAnnotations(podUID string) map[string]string {
+	return r.current.Load().PodAnnotations[podUID]
+}
+
+// GetContainerResources returns the recorded memory resources of a container in the current frame.
+func (r *ReplayFinder) GetContainerResources(containerID string) (*ContainerResources, error) {
+	res, found := r.current.Load().ContainerResources[containerID]
+	if !found {
+		return nil, fmt.Errorf("resources of container %s not recorded", containerID)
+	}
+	return res, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SharedObjectBytes is the memory of a single file or shmem object shared by a pair of containers.
+type SharedObjectBytes struct {
+	Path  string `json:"path"`
+	Dev   string `json:"dev"`
+	Inode string `json:"inode"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SharedMemoryPair holds the memory shared by two containers.
+type SharedMemoryPair struct {
+	A           ContainerRef        `json:"a"`
+	B           ContainerRef        `json:"b"`
+	SharedBytes int64               `json:"shared_bytes"`
+	Objects     []SharedObjectBytes `json:"objects"`
+}
+
+// SharedMemoryReport describes which containers share physical pages of file-backed and shmem mappings.
+type SharedMemoryReport struct {
+	Time time.Time `json:"time"`
+
+	// Precise is true when all shared bytes are computed from page frame numbers,
+	// otherwise some are estimated from the Shared_Clean, Shared_Dirty and Pss fields of smaps.
+	Precise bool               `json:"precise"`
+	Pairs   []SharedMemoryPair `json:"pairs"`
+}
+
+// sharedObject identifies a file or shmem object mapped by processes.
+type sharedObject struct {
+	dev, inode string
+}
+
+// objectUsage sums up the mappings of a shared object by the processes of a container.
+type objectUsage struct {
+	processes int
+	// shared is the largest Shared_Clean + Shared_Dirty of the processes.
+	shared int64
+	// rss is the largest Rss of the processes.
+	rss int64
+	// pss is the sum of Pss of the processes.
+	pss int64
+}
+
+// sharedOutside estimates the bytes of the object the container shares with other containers.
+//
+// Shared_Clean and Shared_Dirty count the pages also mapped by any other process.
+// With a single process they are all mapped outside the container, but with several
+// processes they also count the pages the processes share among themselves. Then a
+// lower bound is used instead: a page mapped only within the container adds exactly
+// one page to the summed Pss of its processes, so the resident pages beyond the
+// summed Pss must be mapped outside the container.
+func (u objectUsage) sharedOutside() int64 {
+	if u.processes == 1 {
+		return u.shared
+	}
+	return min(u.shared, max(u.rss-u.pss, 0))
+}
+
+// BuildSharedMemoryReport groups the file-backed and shmem mappings of all processes by (dev, inode),
+// and computes how many bytes of each object every pair of containers shares.
+//
+// Objects found in pfns, which holds the page frame numbers of the objects read completely
+// by collectSharedPFNs, are counted exactly from the pages mapped by both containers.
+// Other objects are estimated: a pair shares at most the smaller of the bytes each container
+// shares with other containers, which is used as the estimate. The report is precise only
+// when all objects are counted exactly.
+func BuildSharedMemoryReport(snapshots []*ProcessSnapshot, pfns map[sharedObject]map[ContainerRef]map[uint64]bool, now time.Time) *SharedMemoryReport {
+	paths := make(map[sharedObject]string)
+	usage := make(map[sharedObject]map[ContainerRef]objectUsage)
+	for _, ps := range snapshots {
+		ref := ps.Target.ContainerRef()
+		for _, m := range ps.Mappings {
+			category := MappingCategory(m.Path)
+			if m.Inode == "0" || (category != CategoryFile && category != CategoryShmem) {
+				continue
+			}
+			key := sharedObject{m.Dev, m.Inode}
+			paths[key] = m.Path
+			if usage[key] == nil {
+				usage[key] = make(map[ContainerRef]objectUsage)
+			}
+			u := usage[key][ref]
+			u.processes++
+			u.shared = max(u.shared, m.SharedCleanBytes+m.SharedDirtyBytes)
+			u.rss = max(u.rss, m.RssBytes)
+			u.pss += m.PssBytes
+			usage[key][ref] = u
+		}
+	}
+
+	pageSize := int64(os.Getpagesize())
+	precise := pfns != nil
+	pairs := make(map[[2]ContainerRef]*SharedMemoryPair)
+	for key, containers := range usage {
+		refs := make([]ContainerRef, 0, len(containers))
+		for ref := range containers {
+			refs = append(refs, ref)
+		}
+		sortContainerRefs(refs)
+		objectPFNs, exact := pfns[key]
+		if len(refs) > 1 && !exact {
+			precise = false
+		}
+
+		for i := range refs {
+			for j := i + 1; j < len(refs); j++ {
+				a, b := refs[i], refs[j]
+				var bytes int64
+				if exact {
+					for pfn := range objectPFNs[a] {
+						if objectPFNs[b][pfn] {
+							bytes += pageSize
+						}
+					}
+				} else {
+					bytes = min(containers[a].sharedOutside(), containers[b].sharedOutside())
+				}
+				if bytes == 0 {
+					continue
+				}
+				pair, found := pairs[[2]ContainerRef{a, b}]
+				if !found {
+					pair = &SharedMemoryPair{A: a, B: b}
+					pairs[[2]ContainerRef{a, b}] = pair
+				}
+				pair.SharedBytes += bytes
+				pair.Objects = append(pair.Objects, SharedObjectBytes{Path: paths[key], Dev: key.dev, Inode: key.inode, Bytes: bytes})
+			}
+		}
+	}
+
+	report := &SharedMemoryReport{Time: now, Precise: precise, Pairs: []SharedMemoryPair{}}
+	for _, pair := range pairs {
+		sort.Slice(pair.Objects, func(i, j int) bool { return pair.Objects[i].Bytes > pair.Objects[j].Bytes })
+		report.Pairs = append(report.Pairs, *pair)
+	}
+	sort.Slice(report.Pairs, func(i, j int) bool { return report.Pairs[i].SharedBytes > report.Pairs[j].SharedBytes })
+	return report
+}
+
+// collectSharedPFNs reads the page frame numbers of the present pages of file-backed and
+// shmem mappings from pagemap, for a precise shared memory report. At most maxPages pages are read.
+//
+// Only the objects whose mappings were all read are returned, so that an object read
+// partially, e.g. when the budget runs out, is estimated rather than undercounted.
+// complete is false when objects were left out.
+func collectSharedPFNs(snapshots []*ProcessSnapshot, maxPages int64) (pfns map[sharedObject]map[ContainerRef]map[uint64]bool, complete bool) {
+	pageSize := int64(os.Getpagesize())
+	pfns = make(map[sharedObject]map[ContainerRef]map[uint64]bool)
+	incomplete := make(map[sharedObject]bool)
+	budget := maxPages
+	for _, ps := range snapshots {
+		ref := ps.Target.ContainerRef()
+		pagemap, openErr := os.Open(filepath.Join(*procPath, strconv.Itoa(ps.Target.PID), "pagemap"))
+		for _, vma := range ps.VMAs {
+			category := MappingCategory(vma.Path)
+			if vma.Inode == "0" || (category != CategoryFile && category != CategoryShmem) {
+				continue
+			}
+			key := sharedObject{vma.Dev, vma.Inode}
+			start, end, err := vma.Addresses()
+			if openErr != nil || err != nil {
+				incomplete[key] = true
+				continue
+			}
+			if pfns[key] == nil {
+				pfns[key] = make(map[ContainerRef]map[uint64]bool)
+			}
+			if pfns[key][ref] == nil {
+				pfns[key][ref] = make(map[uint64]bool)
+			}
+			err = walkPagemap(pagemap, start, end, pageSize, func(_ uint64, entries []uint64) error {
+				if int64(len(entries)) > budget {
+					return errPageScanBudget
+				}
+				budget -= int64(len(entries))
+				for _, e := range entries {
+					if pfn := e & pagemapPFNMask; e&pagemapPresent != 0 && pfn != 0 {
+						pfns[key][ref][pfn] = true
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				incomplete[key] = true
+			}
+		}
+		if openErr == nil {
+			pagemap.Close()
+		}
+	}
+	for key := range incomplete {
+		delete(pfns, key)
+	}
+	return pfns, len(incomplete) == 0
+}
+
+// sortContainerRefs sorts containers by namespace, pod and container name.
+func sortContainerRefs(refs []ContainerRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		if refs[i].Pod != refs[j].Pod {
+			return refs[i].Pod < refs[j].Pod
+		}
+		return refs[i].Container < refs[j].Container
+	})
+}
+
+// latestSharedMemoryReport holds the report of the most recent poll.
+var latestSharedMemoryReport atomic.Pointer[SharedMemoryReport]
+
+// sharedMemoryHandler serves the latest shared memory report as JSON.
+func sharedMemoryHandler(w http.ResponseWriter, r *http.Request) {
+	report := latestSharedMemoryReport.Load()
+	if report == nil {
+		http.Error(w, "shared memory report not available yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
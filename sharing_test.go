@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sharingTestProcess returns a process of the given container mapping /usr/lib/libfoo.so.
+func sharingTestProcess(container string, pid int, libfoo *SmapsMapping) *ProcessSnapshot {
+	libfoo.Path, libfoo.Dev, libfoo.Inode = "/usr/lib/libfoo.so", "08:01", "1234"
+	return &ProcessSnapshot{
+		Target:   Target{Namespace: "default", Pod: container + "-0", Container: container, PID: pid},
+		Mappings: []*SmapsMapping{libfoo},
+	}
+}
+
+// sharedBytes returns the bytes shared by the containers a and b in the report.
+func sharedBytes(report *SharedMemoryReport, a, b string) int64 {
+	for _, pair := range report.Pairs {
+		if pair.A.Container == a && pair.B.Container == b {
+			return pair.SharedBytes
+		}
+	}
+	return 0
+}
+
+func TestBuildSharedMemoryReportEstimate(t *testing.T) {
+	snapshots := []*ProcessSnapshot{
+		// A single process shares 8 KiB with processes outside of its container.
+		sharingTestProcess("a", 1, &SmapsMapping{RssBytes: 16 << 10, PssBytes: 12 << 10, SharedCleanBytes: 8 << 10}),
+		sharingTestProcess("b", 2, &SmapsMapping{RssBytes: 12 << 10, PssBytes: 8 << 10, SharedCleanBytes: 12 << 10}),
+		// Two processes share 16 KiB only among themselves: their Pss adds up to their Rss.
+		sharingTestProcess("c", 3, &SmapsMapping{RssBytes: 16 << 10, PssBytes: 8 << 10, SharedCleanBytes: 16 << 10}),
+		sharingTestProcess("c", 4, &SmapsMapping{RssBytes: 16 << 10, PssBytes: 8 << 10, SharedCleanBytes: 16 << 10}),
+	}
+	report := BuildSharedMemoryReport(snapshots, nil, time.Unix(1000, 0))
+	if report.Precise {
+		t.Error("estimated report is marked precise")
+	}
+	if got := sharedBytes(report, "a", "b"); got != 8<<10 {
+		t.Errorf("a and b share %d bytes, want %d", got, 8<<10)
+	}
+	for _, other := range []string{"a", "b"} {
+		if got := sharedBytes(report, other, "c"); got != 0 {
+			t.Errorf("%s and c share %d bytes, want 0 since c shares only within the container", other, got)
+		}
+	}
+}
+
+func TestBuildSharedMemoryReportPFNs(t *testing.T) {
+	snapshots := []*ProcessSnapshot{
+		sharingTestProcess("a", 1, &SmapsMapping{RssBytes: 12 << 10, SharedCleanBytes: 12 << 10}),
+		sharingTestProcess("b", 2, &SmapsMapping{RssBytes: 12 << 10, SharedCleanBytes: 12 << 10}),
+	}
+	pageSize := int64(os.Getpagesize())
+	libfoo := sharedObject{"08:01", "1234"}
+
+	// The page frames 2 and 3 are mapped by both containers.
+	pfns := map[sharedObject]map[ContainerRef]map[uint64]bool{
+		libfoo: {
+			snapshots[0].Target.ContainerRef(): {1: true, 2: true, 3: true},
+			snapshots[1].Target.ContainerRef(): {2: true, 3: true, 4: true},
+		},
+	}
+	report := BuildSharedMemoryReport(snapshots, pfns, time.Unix(1000, 0))
+	if !report.Precise {
+		t.Error("report counted from page frame numbers is not marked precise")
+	}
+	if got := sharedBytes(report, "a", "b"); got != 2*pageSize {
+		t.Errorf("a and b share %d bytes, want %d", got, 2*pageSize)
+	}
+
+	// Objects left out by collectSharedPFNs are estimated.
+	report = BuildSharedMemoryReport(snapshots, map[sharedObject]map[ContainerRef]map[uint64]bool{}, time.Unix(1000, 0))
+	if report.Precise {
+		t.Error("report with estimated objects is marked precise")
+	}
+	if got := sharedBytes(report, "a", "b"); got != 12<<10 {
+		t.Errorf("a and b share %d bytes, want the estimate %d", got, 12<<10)
+	}
+}
+
+func TestCollectSharedPFNsBudget(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	dir := t.TempDir()
+	savedProcPath := *procPath
+	t.Cleanup(func() { *procPath = savedProcPath })
+	*procPath = dir
+
+	// Both processes map two pages of libfoo, and the budget covers only the first one.
+	var snapshots []*ProcessSnapshot
+	for _, pid := range []int{1, 2} {
+		writePagemap(t, filepath.Join(dir, fmt.Sprint(pid), "pagemap"), 16, []uint64{pagemapPresent | 100, pagemapPresent | 101})
+		ps := sharingTestProcess(fmt.Sprint("c", pid), pid, &SmapsMapping{})
+		ps.VMAs = []*SmapsMapping{{AddrRange: fmt.Sprintf("%x-%x", 16*pageSize, 18*pageSize), Path: "/usr/lib/libfoo.so", Dev: "08:01", Inode: "1234"}}
+		snapshots = append(snapshots, ps)
+	}
+	libfoo := sharedObject{"08:01", "1234"}
+
+	pfns, complete := collectSharedPFNs(snapshots, 4)
+	if !complete || len(pfns[libfoo]) != 2 || len(pfns[libfoo][snapshots[1].Target.ContainerRef()]) != 2 {
+		t.Errorf("got complete %v and page frames %v, want all of them", complete, pfns)
+	}
+
+	pfns, complete = collectSharedPFNs(snapshots, 2)
+	if complete {
+		t.Error("got complete with the budget exhausted")
+	}
+	if _, found := pfns[libfoo]; found {
+		t.Errorf("partially read object returned: %v", pfns[libfoo])
+	}
+}
@@ -2,46 +2,86 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// PageSize identifies the kernel and MMU page sizes backing a VMA.
+type PageSize struct {
+	KernelBytes int64
+	MMUBytes    int64
+}
+
+// MarshalText formats the page size as "<kernel>/<mmu>" in bytes, e.g. "4096/4096".
+func (p PageSize) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%d/%d", p.KernelBytes, p.MMUBytes), nil
+}
+
+// UnmarshalText parses a page size formatted by MarshalText.
+func (p *PageSize) UnmarshalText(text []byte) error {
+	kernel, mmu, found := strings.Cut(string(text), "/")
+	if !found {
+		return fmt.Errorf("invalid page size %q", text)
+	}
+	var err error
+	if p.KernelBytes, err = strconv.ParseInt(kernel, 10, 64); err != nil {
+		return err
+	}
+	p.MMUBytes, err = strconv.ParseInt(mmu, 10, 64)
+	return err
+}
+
 // SmapsMapping describes a memory mapping entry parsed from smaps.
-// The AddrRange field is omitted
-// Aggregation is performed by (perms, path)
+// For aggregated mappings the header fields are those of the first VMA.
+// Aggregation is performed by path.
 type SmapsMapping struct {
 	// Header fields
-	AddrRange string
-	Perms     string
-	Offset    string
-	Dev       string
-	Inode     string
-	Path      string
+	AddrRange string `json:"addr_range"`
+	Perms     string `json:"perms"`
+	Offset    string `json:"offset"`
+	Dev       string `json:"dev"`
+	Inode     string `json:"inode"`
+	Path      string `json:"path"`
 
 	// Key-Value fields (all values in bytes)
-	SizeBytes           int64
-	RssBytes            int64
-	PssBytes            int64
-	PssDirtyBytes       int64
-	SharedCleanBytes    int64
-	SharedDirtyBytes    int64
-	PrivateCleanBytes   int64
-	PrivateDirtyBytes   int64
-	ReferencedBytes     int64
-	AnonymousBytes      int64
-	LazyFreeBytes       int64
-	AnonHugePagesBytes  int64
-	ShmemPmdMappedBytes int64
-	SharedHugetlbBytes  int64
-	PrivateHugetlbBytes int64
-	SwapBytes           int64
-	SwapPssBytes        int64
-	KernelPageSizeBytes int64
-	MMUPageSizeBytes    int64
-	LockedBytes         int64
+	SizeBytes           int64 `json:"size_bytes"`
+	RssBytes            int64 `json:"rss_bytes"`
+	PssBytes            int64 `json:"pss_bytes"`
+	PssDirtyBytes       int64 `json:"pss_dirty_bytes"`
+	SharedCleanBytes    int64 `json:"shared_clean_bytes"`
+	SharedDirtyBytes    int64 `json:"shared_dirty_bytes"`
+	PrivateCleanBytes   int64 `json:"private_clean_bytes"`
+	PrivateDirtyBytes   int64 `json:"private_dirty_bytes"`
+	ReferencedBytes     int64 `json:"referenced_bytes"`
+	AnonymousBytes      int64 `json:"anonymous_bytes"`
+	LazyFreeBytes       int64 `json:"lazy_free_bytes"`
+	AnonHugePagesBytes  int64 `json:"anon_huge_pages_bytes"`
+	ShmemPmdMappedBytes int64 `json:"shmem_pmd_mapped_bytes"`
+	SharedHugetlbBytes  int64 `json:"shared_hugetlb_bytes"`
+	PrivateHugetlbBytes int64 `json:"private_hugetlb_bytes"`
+	SwapBytes           int64 `json:"swap_bytes"`
+	SwapPssBytes        int64 `json:"swap_pss_bytes"`
+	KernelPageSizeBytes int64 `json:"kernel_page_size_bytes"`
+	MMUPageSizeBytes    int64 `json:"mmu_page_size_bytes"`
+	LockedBytes         int64 `json:"locked_bytes"`
+	KSMBytes            int64 `json:"ksm_bytes"`
+
+	// VmFlags lists the two-letter flags of the VMA, e.g. "mg" for MADV_MERGEABLE.
+	// For aggregated mappings it is the union of the flags of all VMAs.
+	VmFlags []string `json:"vm_flags"`
+
+	// VMACount is the number of VMAs merged into the mapping.
+	VMACount int `json:"vma_count"`
+
+	// RssBytesByPageSize breaks down Rss by the page sizes of the VMAs.
+	// Page sizes are per-VMA attributes and are never summed.
+	RssBytesByPageSize map[PageSize]int64 `json:"rss_bytes_by_page_size,omitempty"`
 }
 
 var (
@@ -54,9 +94,18 @@ var (
 	kvRe = regexp.MustCompile(`^([A-Za-z_]+):\s+(\d+) kB`)
 )
 
-// ParseSmaps parses the contents of a /proc/[pid]/smaps file.
+// ParseSmaps parses the contents of a /proc/[pid]/smaps file and aggregates the VMAs by path.
 func ParseSmaps(r io.Reader) ([]*SmapsMapping, error) {
-	aggregatedSmaps := make(map[string]*SmapsMapping)
+	vmas, err := ParseSmapsVMAs(r)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateSmaps(vmas), nil
+}
+
+// ParseSmapsVMAs parses the contents of a /proc/[pid]/smaps file and returns one entry per VMA.
+func ParseSmapsVMAs(r io.Reader) ([]*SmapsMapping, error) {
+	var vmas []*SmapsMapping
 
 	scanner := bufio.NewScanner(r)
 	var mapping *SmapsMapping
@@ -78,25 +127,20 @@ func ParseSmaps(r io.Reader) ([]*SmapsMapping, error) {
 				path = matches[6]
 			}
 
-			tmp := &SmapsMapping{
+			mapping = &SmapsMapping{
 				AddrRange: matches[1],
 				Perms:     perms,
 				Offset:    matches[3],
 				Dev:       matches[4],
 				Inode:     matches[5],
 				Path:      strings.TrimSpace(path),
+				VMACount:  1,
 			}
+			vmas = append(vmas, mapping)
+			continue
+		}
 
-			// Use path as the aggregation key.
-			key := tmp.Path
-
-			if existing, found := aggregatedSmaps[key]; found {
-				mapping = existing
-			} else {
-				aggregatedSmaps[key] = tmp
-				mapping = tmp
-			}
-
+		if mapping == nil {
 			continue
 		}
 
@@ -106,47 +150,53 @@ func ParseSmaps(r io.Reader) ([]*SmapsMapping, error) {
 			valBytes := val * 1024
 			switch key {
 			case "Size":
-				mapping.SizeBytes += valBytes
+				mapping.SizeBytes = valBytes
 			case "KernelPageSize":
-				mapping.KernelPageSizeBytes += valBytes
+				mapping.KernelPageSizeBytes = valBytes
 			case "MMUPageSize":
-				mapping.MMUPageSizeBytes += valBytes
+				mapping.MMUPageSizeBytes = valBytes
 			case "Rss":
-				mapping.RssBytes += valBytes
+				mapping.RssBytes = valBytes
 			case "Pss":
-				mapping.PssBytes += valBytes
+				mapping.PssBytes = valBytes
 			case "Pss_Dirty":
-				mapping.PssDirtyBytes += valBytes
+				mapping.PssDirtyBytes = valBytes
 			case "Shared_Clean":
-				mapping.SharedCleanBytes += valBytes
+				mapping.SharedCleanBytes = valBytes
 			case "Shared_Dirty":
-				mapping.SharedDirtyBytes += valBytes
+				mapping.SharedDirtyBytes = valBytes
 			case "Private_Clean":
-				mapping.PrivateCleanBytes += valBytes
+				mapping.PrivateCleanBytes = valBytes
 			case "Private_Dirty":
-				mapping.PrivateDirtyBytes += valBytes
+				mapping.PrivateDirtyBytes = valBytes
 			case "Referenced":
-				mapping.ReferencedBytes += valBytes
+				mapping.ReferencedBytes = valBytes
 			case "Anonymous":
-				mapping.AnonymousBytes += valBytes
+				mapping.AnonymousBytes = valBytes
 			case "LazyFree":
-				mapping.LazyFreeBytes += valBytes
+				mapping.LazyFreeBytes = valBytes
 			case "AnonHugePages":
-				mapping.AnonHugePagesBytes += valBytes
+				mapping.AnonHugePagesBytes = valBytes
 			case "ShmemPmdMapped":
-				mapping.ShmemPmdMappedBytes += valBytes
+				mapping.ShmemPmdMappedBytes = valBytes
 			case "Shared_Hugetlb":
-				mapping.SharedHugetlbBytes += valBytes
+				mapping.SharedHugetlbBytes = valBytes
 			case "Private_Hugetlb":
-				mapping.PrivateHugetlbBytes += valBytes
+				mapping.PrivateHugetlbBytes = valBytes
 			case "Swap":
-				mapping.SwapBytes += valBytes
+				mapping.SwapBytes = valBytes
 			case "SwapPss":
-				mapping.SwapPssBytes += valBytes
+				mapping.SwapPssBytes = valBytes
 			case "Locked":
-				mapping.LockedBytes += valBytes
+				mapping.LockedBytes = valBytes
+			case "KSM":
+				mapping.KSMBytes = valBytes
 			}
+			continue
+		}
 
+		if flags, found := strings.CutPrefix(line, "VmFlags:"); found {
+			mapping.VmFlags = strings.Fields(flags)
 		}
 	}
 
@@ -154,11 +204,180 @@ func ParseSmaps(r io.Reader) ([]*SmapsMapping, error) {
 		return nil, fmt.Errorf("scan error: %w", err)
 	}
 
-	// Convert map to slice
-	result := make([]*SmapsMapping, 0, len(aggregatedSmaps))
-	for _, m := range aggregatedSmaps {
-		result = append(result, m)
+	// Page sizes are only known once all fields of the VMA have been read.
+	for _, m := range vmas {
+		m.RssBytesByPageSize = map[PageSize]int64{m.PageSize(): m.RssBytes}
 	}
 
-	return result, nil
+	return vmas, nil
+}
+
+// AggregateSmaps merges VMAs with the same path into a single mapping.
+// Byte counters are summed, while KernelPageSizeBytes and MMUPageSizeBytes are
+// kept only if all merged VMAs agree on them; RssBytesByPageSize holds the breakdown.
+func AggregateSmaps(vmas []*SmapsMapping) []*SmapsMapping {
+	aggregatedSmaps := make(map[string]*SmapsMapping)
+	var result []*SmapsMapping
+
+	for _, vma := range vmas {
+		// Use path as the aggregation key.
+		key := vma.Path
+
+		existing, found := aggregatedSmaps[key]
+		if !found {
+			tmp := *vma
+			tmp.VmFlags = slices.Clone(vma.VmFlags)
+			tmp.RssBytesByPageSize = make(map[PageSize]int64)
+			for ps, rss := range vma.RssBytesByPageSize {
+				tmp.RssBytesByPageSize[ps] = rss
+			}
+			aggregatedSmaps[key] = &tmp
+			result = append(result, &tmp)
+			continue
+		}
+
+		existing.VMACount += vma.VMACount
+		existing.SizeBytes += vma.SizeBytes
+		existing.RssBytes += vma.RssBytes
+		existing.PssBytes += vma.PssBytes
+		existing.PssDirtyBytes += vma.PssDirtyBytes
+		existing.SharedCleanBytes += vma.SharedCleanBytes
+		existing.SharedDirtyBytes += vma.SharedDirtyBytes
+		existing.PrivateCleanBytes += vma.PrivateCleanBytes
+		existing.PrivateDirtyBytes += vma.PrivateDirtyBytes
+		existing.ReferencedBytes += vma.ReferencedBytes
+		existing.AnonymousBytes += vma.AnonymousBytes
+		existing.LazyFreeBytes += vma.LazyFreeBytes
+		existing.AnonHugePagesBytes += vma.AnonHugePagesBytes
+		existing.ShmemPmdMappedBytes += vma.ShmemPmdMappedBytes
+		existing.SharedHugetlbBytes += vma.SharedHugetlbBytes
+		existing.PrivateHugetlbBytes += vma.PrivateHugetlbBytes
+		existing.SwapBytes += vma.SwapBytes
+		existing.SwapPssBytes += vma.SwapPssBytes
+		existing.LockedBytes += vma.LockedBytes
+		existing.KSMBytes += vma.KSMBytes
+		for _, flag := range vma.VmFlags {
+			if !slices.Contains(existing.VmFlags, flag) {
+				existing.VmFlags = append(existing.VmFlags, flag)
+			}
+		}
+
+		if existing.KernelPageSizeBytes != vma.KernelPageSizeBytes {
+			existing.KernelPageSizeBytes = 0
+		}
+		if existing.MMUPageSizeBytes != vma.MMUPageSizeBytes {
+			existing.MMUPageSizeBytes = 0
+		}
+		for ps, rss := range vma.RssBytesByPageSize {
+			existing.RssBytesByPageSize[ps] += rss
+		}
+	}
+
+	return result
+}
+
+// Mergeable checks if the mapping has been marked with madvise(MADV_MERGEABLE) for KSM.
+func (m *SmapsMapping) Mergeable() bool {
+	return slices.Contains(m.VmFlags, "mg")
+}
+
+// PageSize returns the page sizes of a single VMA.
+func (m *SmapsMapping) PageSize() PageSize {
+	return PageSize{KernelBytes: m.KernelPageSizeBytes, MMUBytes: m.MMUPageSizeBytes}
+}
+
+// Mapping categories returned by MappingCategory.
+const (
+	CategoryHeap    = "heap"
+	CategoryStack   = "stack"
+	CategoryAnon    = "anon"
+	CategoryFile    = "file"
+	CategoryShmem   = "shmem"
+	CategoryDevice  = "device"
+	CategorySpecial = "special"
+)
+
+// MappingCategory classifies a mapping by its path.
+func MappingCategory(path string) string {
+	switch {
+	case path == "[heap]":
+		return CategoryHeap
+	case path == "[stack]" || strings.HasPrefix(path, "[stack:"):
+		return CategoryStack
+	case path == "[anon]" || strings.HasPrefix(path, "[anon:"):
+		return CategoryAnon
+	case strings.HasPrefix(path, "["):
+		// [vdso], [vvar], [vsyscall], [uprobes] and other kernel provided mappings.
+		return CategorySpecial
+	case strings.HasPrefix(path, "/dev/shm/"), strings.HasPrefix(path, "/SYSV"), strings.HasPrefix(path, "/memfd:"), strings.HasPrefix(path, "/dev/zero"):
+		return CategoryShmem
+	case strings.HasPrefix(path, "/dev/"):
+		return CategoryDevice
+	default:
+		return CategoryFile
+	}
+}
+
+// MarshalJSON encodes the mapping with its category, so that API clients do not
+// need to classify the path themselves.
+func (m *SmapsMapping) MarshalJSON() ([]byte, error) {
+	type mapping SmapsMapping
+	return json.Marshal(struct {
+		*mapping
+		Category string `json:"category"`
+	}{(*mapping)(m), MappingCategory(m.Path)})
+}
+
+// AddressSpace summarizes the virtual address space layout of a process.
+type AddressSpace struct {
+	VMACount        int
+	VirtualBytes    int64
+	LargestGapBytes int64
+}
+
+// SummarizeAddressSpace computes address space statistics from the VMAs returned by ParseSmapsVMAs.
+// The [vsyscall] page lives at a fixed address far above the rest of the
+// address space and is excluded from the gap calculation.
+func SummarizeAddressSpace(vmas []*SmapsMapping) AddressSpace {
+	type addrRange struct{ start, end uint64 }
+
+	summary := AddressSpace{VMACount: len(vmas)}
+	var ranges []addrRange
+	for _, vma := range vmas {
+		summary.VirtualBytes += vma.SizeBytes
+		if vma.Path == "[vsyscall]" {
+			continue
+		}
+		start, end, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, addrRange{start, end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	for i := 1; i < len(ranges); i++ {
+		if gap := int64(ranges[i].start - ranges[i-1].end); ranges[i].start > ranges[i-1].end && gap > summary.LargestGapBytes {
+			summary.LargestGapBytes = gap
+		}
+	}
+
+	return summary
+}
+
+// Addresses returns the start and end addresses of the mapping parsed from AddrRange.
+func (m *SmapsMapping) Addresses() (uint64, uint64, error) {
+	startStr, endStr, found := strings.Cut(m.AddrRange, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid address range: %s", m.AddrRange)
+	}
+	start, err := strconv.ParseUint(startStr, 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.ParseUint(endStr, 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSmapsMappingJSONHasCategory(t *testing.T) {
+	data, err := json.Marshal([]*SmapsMapping{{Path: "[heap]", RssBytes: 4096}, {Path: "/dev/shm/buf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got[0]["category"] != CategoryHeap || got[0]["rss_bytes"] != 4096.0 || got[1]["category"] != CategoryShmem {
+		t.Errorf("got %s", data)
+	}
+}
+
+func TestParseSmapsRssByPageSize(t *testing.T) {
+	const smaps = `7f0000000000-7f0000200000 rw-s 00000000 00:0f 1234                       /dev/hugepages/buf
+Size:               2048 kB
+KernelPageSize:     2048 kB
+MMUPageSize:        2048 kB
+Rss:                2048 kB
+VmFlags: rd wr sh mr mw me ms de ht
+7f0000200000-7f0000201000 rw-s 00000000 00:0f 1234                       /dev/hugepages/buf
+Size:                  4 kB
+KernelPageSize:        4 kB
+MMUPageSize:           4 kB
+Rss:                   4 kB
+VmFlags: rd wr sh mr mw me ms
+7ffc00000000-7ffc00021000 rw-p 00000000 00:00 0                          [stack]
+Size:                132 kB
+KernelPageSize:        4 kB
+MMUPageSize:           4 kB
+Rss:                  16 kB
+VmFlags: rd wr mr mw me gd ac
+`
+	mappings, err := ParseSmaps(strings.NewReader(smaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path           string
+		want           map[PageSize]int64
+		kernelPageSize int64
+		mmuPageSize    int64
+		wantVMACount   int
+	}{
+		{
+			path:         "/dev/hugepages/buf",
+			want:         map[PageSize]int64{{2 << 20, 2 << 20}: 2 << 20, {4096, 4096}: 4096},
+			wantVMACount: 2,
+		},
+		{
+			path:           "[stack]",
+			want:           map[PageSize]int64{{4096, 4096}: 16 << 10},
+			kernelPageSize: 4096,
+			mmuPageSize:    4096,
+			wantVMACount:   1,
+		},
+	}
+	for _, tt := range tests {
+		i := slices.IndexFunc(mappings, func(m *SmapsMapping) bool { return m.Path == tt.path })
+		if i < 0 {
+			t.Fatalf("mapping %s not found", tt.path)
+		}
+		m := mappings[i]
+		if !maps.Equal(m.RssBytesByPageSize, tt.want) {
+			t.Errorf("%s: got RSS by page size %v, want %v", tt.path, m.RssBytesByPageSize, tt.want)
+		}
+		// Page sizes of an aggregated mapping are kept only when all its VMAs agree.
+		if m.KernelPageSizeBytes != tt.kernelPageSize || m.MMUPageSizeBytes != tt.mmuPageSize || m.VMACount != tt.wantVMACount {
+			t.Errorf("%s: got page sizes %d/%d and %d VMAs", tt.path, m.KernelPageSizeBytes, m.MMUPageSizeBytes, m.VMACount)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot file format written by WriteSnapshot.
+// It is incremented whenever a change would make older readers misinterpret the file.
+const SnapshotVersion = 1
+
+// Snapshot is a complete capture of the smaps of the selected processes.
+//
+// The snapshot types define the file format and are kept separate from the
+// types of the API, which can change without a new SnapshotVersion.
+type Snapshot struct {
+	Version       int                `json:"version"`
+	Time          time.Time          `json:"time"`
+	Hostname      string             `json:"hostname"`
+	KernelRelease string             `json:"kernel_release"`
+	Selector      string             `json:"selector"`
+	Processes     []*SnapshotProcess `json:"processes"`
+}
+
+// SnapshotProcess is a process in a snapshot.
+type SnapshotProcess struct {
+	Namespace   string             `json:"namespace"`
+	Pod         string             `json:"pod"`
+	Container   string             `json:"container"`
+	ContainerID string             `json:"container_id"`
+	PID         int                `json:"pid"`
+	Comm        string             `json:"comm"`
+	Cmdline     []string           `json:"cmdline"`
+	Totals      SnapshotTotals     `json:"totals"`
+	Mappings    []*SnapshotMapping `json:"mappings"`
+}
+
+// SnapshotTotals holds the memory totals of a process in a snapshot.
+type SnapshotTotals struct {
+	RssBytes         int64 `json:"rss_bytes"`
+	PssBytes         int64 `json:"pss_bytes"`
+	RssAnonBytes     int64 `json:"rss_anon_bytes"`
+	RssFileBytes     int64 `json:"rss_file_bytes"`
+	SwapBytes        int64 `json:"swap_bytes"`
+	SwapPssBytes     int64 `json:"swap_pss_bytes"`
+	UssBytes         int64 `json:"uss_bytes"`
+	FootprintBytes   int64 `json:"footprint_bytes"`
+	ReclaimableBytes int64 `json:"reclaimable_bytes"`
+}
+
+// SnapshotMapping is a VMA of a process in a snapshot.
+type SnapshotMapping struct {
+	AddrRange string `json:"addr_range"`
+	Perms     string `json:"perms"`
+	Offset    string `json:"offset"`
+	Dev       string `json:"dev"`
+	Inode     string `json:"inode"`
+	Path      string `json:"path"`
+
+	SizeBytes           int64    `json:"size_bytes"`
+	RssBytes            int64    `json:"rss_bytes"`
+	PssBytes            int64    `json:"pss_bytes"`
+	PssDirtyBytes       int64    `json:"pss_dirty_bytes"`
+	SharedCleanBytes    int64    `json:"shared_clean_bytes"`
+	SharedDirtyBytes    int64    `json:"shared_dirty_bytes"`
+	PrivateCleanBytes   int64    `json:"private_clean_bytes"`
+	PrivateDirtyBytes   int64    `json:"private_dirty_bytes"`
+	ReferencedBytes     int64    `json:"referenced_bytes"`
+	AnonymousBytes      int64    `json:"anonymous_bytes"`
+	LazyFreeBytes       int64    `json:"lazy_free_bytes"`
+	AnonHugePagesBytes  int64    `json:"anon_huge_pages_bytes"`
+	ShmemPmdMappedBytes int64    `json:"shmem_pmd_mapped_bytes"`
+	SharedHugetlbBytes  int64    `json:"shared_hugetlb_bytes"`
+	PrivateHugetlbBytes int64    `json:"private_hugetlb_bytes"`
+	SwapBytes           int64    `json:"swap_bytes"`
+	SwapPssBytes        int64    `json:"swap_pss_bytes"`
+	KernelPageSizeBytes int64    `json:"kernel_page_size_bytes"`
+	MMUPageSizeBytes    int64    `json:"mmu_page_size_bytes"`
+	LockedBytes         int64    `json:"locked_bytes"`
+	KSMBytes            int64    `json:"ksm_bytes"`
+	VmFlags             []string `json:"vm_flags"`
+}
+
+// NewSnapshot captures every VMA of the snapshots.
+func NewSnapshot(snapshots []*ProcessSnapshot, selector string, now time.Time) *Snapshot {
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		Time:      now,
+		Selector:  selector,
+		Processes: []*SnapshotProcess{},
+	}
+	for _, ps := range snapshots {
+		cmdline, err := readCmdline(ps.Target.PID)
+		if err != nil {
+			slog.Debug("Failed to read command line", "pid", ps.Target.PID, "error", err)
+		}
+		s.Processes = append(s.Processes, newSnapshotProcess(ps, cmdline))
+	}
+	s.Hostname, _ = os.Hostname()
+	s.KernelRelease = readKernelRelease()
+	return s
+}
+
+// newSnapshotProcess converts a process to its snapshot format.
+func newSnapshotProcess(ps *ProcessSnapshot, cmdline []string) *SnapshotProcess {
+	t := ps.Totals
+	p := &SnapshotProcess{
+		Namespace:   ps.Target.Namespace,
+		Pod:         ps.Target.Pod,
+		Container:   ps.Target.Container,
+		ContainerID: ps.Target.ContainerID,
+		PID:         ps.Target.PID,
+		Comm:        ps.Comm,
+		Cmdline:     cmdline,
+		Totals: SnapshotTotals{
+			RssBytes:         t.RssBytes,
+			PssBytes:         t.PssBytes,
+			RssAnonBytes:     t.RssAnonBytes,
+			RssFileBytes:     t.RssFileBytes,
+			SwapBytes:        t.SwapBytes,
+			SwapPssBytes:     t.SwapPssBytes,
+			UssBytes:         t.UssBytes,
+			FootprintBytes:   t.FootprintBytes,
+			ReclaimableBytes: t.ReclaimableBytes,
+		},
+		Mappings: make([]*SnapshotMapping, 0, len(ps.VMAs)),
+	}
+	for _, m := range ps.VMAs {
+		p.Mappings = append(p.Mappings, &SnapshotMapping{
+			AddrRange:           m.AddrRange,
+			Perms:               m.Perms,
+			Offset:              m.Offset,
+			Dev:                 m.Dev,
+			Inode:               m.Inode,
+			Path:                m.Path,
+			SizeBytes:           m.SizeBytes,
+			RssBytes:            m.RssBytes,
+			PssBytes:            m.PssBytes,
+			PssDirtyBytes:       m.PssDirtyBytes,
+			SharedCleanBytes:    m.SharedCleanBytes,
+			SharedDirtyBytes:    m.SharedDirtyBytes,
+			PrivateCleanBytes:   m.PrivateCleanBytes,
+			PrivateDirtyBytes:   m.PrivateDirtyBytes,
+			ReferencedBytes:     m.ReferencedBytes,
+			AnonymousBytes:      m.AnonymousBytes,
+			LazyFreeBytes:       m.LazyFreeBytes,
+			AnonHugePagesBytes:  m.AnonHugePagesBytes,
+			ShmemPmdMappedBytes: m.ShmemPmdMappedBytes,
+			SharedHugetlbBytes:  m.SharedHugetlbBytes,
+			PrivateHugetlbBytes: m.PrivateHugetlbBytes,
+			SwapBytes:           m.SwapBytes,
+			SwapPssBytes:        m.SwapPssBytes,
+			KernelPageSizeBytes: m.KernelPageSizeBytes,
+			MMUPageSizeBytes:    m.MMUPageSizeBytes,
+			LockedBytes:         m.LockedBytes,
+			KSMBytes:            m.KSMBytes,
+			VmFlags:             m.VmFlags,
+		})
+	}
+	return p
+}
+
+// readKernelRelease returns the release of the running kernel, or an empty string if it cannot be read.
+func readKernelRelease() string {
+	release, err := os.ReadFile(filepath.Join(*procPath, "sys", "kernel", "osrelease"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(release))
+}
+
+// WriteSnapshot writes the snapshot as gzip compressed JSON.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var s Snapshot
+	if err := json.NewDecoder(zr).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected at most %d", s.Version, SnapshotVersion)
+	}
+	return &s, nil
+}
+
+// writeSnapshotFile writes the snapshot to a file.
+func writeSnapshotFile(path string, s *Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteSnapshot(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readSnapshotFile reads a snapshot from a file.
+func readSnapshotFile(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// snapshotCommand implements the snapshot subcommand, which captures the smaps
+// of the selected processes to a file.
+func snapshotCommand(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	output := fs.String("o", "", "File to write the snapshot to, - for standard output (default smaps-<time>.json.gz)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] snapshot [snapshot flags] [namespace/pod/container/command]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Captures the smaps of the selected processes, by default those selected by -filter.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	selector := *processFilter
+	if fs.NArg() == 1 {
+		selector = fs.Arg(0)
+	}
+	filter, err := ParseProcessFilter(selector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid selector, expected namespace/pod/container/command:", err)
+		return 2
+	}
+
+	finder, err := newFinder()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := collectMatchingProcesses(finder, filter, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get targets:", err)
+		return 1
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(os.Stderr, "No matching processes found")
+		return 1
+	}
+	now := time.Now()
+	s := NewSnapshot(snapshots, selector, now)
+
+	path := *output
+	if path == "" {
+		path = "smaps-" + now.UTC().Format("20060102T150405Z") + ".json.gz"
+	}
+	if path == "-" {
+		err = WriteSnapshot(os.Stdout, s)
+	} else {
+		err = writeSnapshotFile(path, s)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write snapshot:", err)
+		return 1
+	}
+	if path != "-" {
+		fmt.Fprintf(os.Stderr, "Wrote %d processes to %s\n", len(s.Processes), path)
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+)
+
+// snapshotV1 is a snapshot in the version 1 file format, which must stay readable.
+const snapshotV1 = `{
+  "version": 1,
+  "time": "2025-01-01T12:00:00Z",
+  "hostname": "node-1",
+  "kernel_release": "6.8.0",
+  "selector": "default/*/app/*",
+  "processes": [{
+    "namespace": "default", "pod": "app-0", "container": "app", "container_id": "cid", "pid": 42,
+    "comm": "java", "cmdline": ["java", "-jar", "app.jar"],
+    "totals": {"rss_bytes": 12288, "pss_bytes": 12288},
+    "mappings": [
+      {"addr_range": "1000-3000", "perms": "rw-p", "path": "[heap]", "size_bytes": 8192, "rss_bytes": 8192, "pss_bytes": 8192, "private_dirty_bytes": 8192, "vm_flags": ["rd", "wr"]},
+      {"addr_range": "3000-4000", "perms": "r--p", "path": "/app.jar", "size_bytes": 4096, "rss_bytes": 4096, "pss_bytes": 4096}
+    ]
+  }]
+}`
+
+func TestReadSnapshotV1(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(snapshotV1))
+	zw.Close()
+
+	s, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Processes) != 1 || len(s.Processes[0].Mappings) != 2 {
+		t.Fatalf("got %+v", s.Processes)
+	}
+	p := s.Processes[0]
+	if p.Comm != "java" || p.Totals.RssBytes != 12288 || len(p.Cmdline) != 3 {
+		t.Errorf("got process %+v", p)
+	}
+	heap := p.Mappings[0]
+	if heap.Path != "[heap]" || heap.PrivateDirtyBytes != 8192 || len(heap.VmFlags) != 2 {
+		t.Errorf("got mapping %+v", heap)
+	}
+}
+
+func TestSnapshotRoundTripAndDiff(t *testing.T) {
+	ps := &ProcessSnapshot{
+		Target: Target{Namespace: "default", Pod: "app-0", Container: "app", PID: 42},
+		Comm:   "java",
+		VMAs:   []*SmapsMapping{{AddrRange: "1000-3000", Path: "[heap]", RssBytes: 8192, PssBytes: 8192}},
+	}
+	before := NewSnapshot([]*ProcessSnapshot{ps}, "*/*/*/*", time.Unix(1000, 0))
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, before); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Version != SnapshotVersion || read.Processes[0].Mappings[0].RssBytes != 8192 {
+		t.Errorf("got %+v", read)
+	}
+
+	// The pod name changes between the snapshots, the container is matched by its name.
+	ps.Target.Pod = "app-1"
+	ps.VMAs = append(ps.VMAs, &SmapsMapping{AddrRange: "3000-4000", Path: "[anon]", RssBytes: 4096})
+	after := NewSnapshot([]*ProcessSnapshot{ps}, "*/*/*/*", time.Unix(2000, 0))
+
+	diff := DiffSnapshots(read, after, 1)
+	if len(diff.Containers) != 1 || len(diff.Containers[0].Mappings) != 1 {
+		t.Fatalf("got %+v", diff.Containers)
+	}
+	md := diff.Containers[0].Mappings[0]
+	if md.Path != "[anon]" || md.Status != DiffAdded || md.New.RssBytes != 4096 {
+		t.Errorf("got mapping diff %+v", md)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// SoftDirtyAnnotation must be set to "true" on a pod to allow the soft-dirty tracker to reset the soft-dirty bits of its processes.
+const SoftDirtyAnnotation = "smaps-container-exporter/soft-dirty"
+
+// clearRefsSoftDirty is written to /proc/[pid]/clear_refs to reset the soft-dirty bits of a process.
+const clearRefsSoftDirty = "4"
+
+// SoftDirtyTracker measures how fast processes write to their memory.
+//
+// Each cycle counts the pages that have their soft-dirty bit set in
+// /proc/[pid]/pagemap, i.e. pages written since the previous cycle, and then
+// resets the bits by writing 4 to /proc/[pid]/clear_refs. Resetting the bits
+// write-protects the pages of the process, which causes page faults on the next
+// write, so processes are only tracked when their pod has SoftDirtyAnnotation
+// set. See https://docs.kernel.org/admin-guide/mm/soft-dirty.html
+type SoftDirtyTracker struct {
+	procPath string
+	interval time.Duration
+	maxPages int64
+	pageSize int64
+
+	lastCycle time.Time
+	cleared   map[Target]time.Time
+	results   map[Target]map[string]float64
+}
+
+// NewSoftDirtyTracker creates a tracker that runs a cycle at most once per interval,
+// reading at most maxPages pages.
+func NewSoftDirtyTracker(procPath string, interval time.Duration, maxPages int64) *SoftDirtyTracker {
+	return &SoftDirtyTracker{
+		procPath: procPath,
+		interval: interval,
+		maxPages: maxPages,
+		pageSize: int64(os.Getpagesize()),
+		cleared:  make(map[Target]time.Time),
+		results:  make(map[Target]map[string]float64),
+	}
+}
+
+// Update runs a cycle if the interval has elapsed and attaches the latest write rates to the snapshots.
+// Annotations holds the annotations of the pods of the snapshots keyed by pod UID.
+func (d *SoftDirtyTracker) Update(snapshots []*ProcessSnapshot, annotations map[string]map[string]string, now time.Time) {
+	if now.Sub(d.lastCycle) >= d.interval {
+		d.lastCycle = now
+		d.cycle(snapshots, annotations, now)
+	}
+	for _, ps := range snapshots {
+		ps.DirtyRate = d.results[ps.Target]
+	}
+}
+
+// cycle counts the soft-dirty pages of the annotated processes and resets their soft-dirty bits.
+// The budget only limits the counting: the bits of every annotated process are reset,
+// so that the processes left out when the budget runs out have a baseline in the next cycle.
+func (d *SoftDirtyTracker) cycle(snapshots []*ProcessSnapshot, annotations map[string]map[string]string, now time.Time) {
+	cleared := make(map[Target]time.Time)
+	results := make(map[Target]map[string]float64)
+	var tracked []*ProcessSnapshot
+	for _, ps := range snapshots {
+		if annotations[ps.Target.PodUID][SoftDirtyAnnotation] == "true" {
+			tracked = append(tracked, ps)
+		}
+	}
+	// Processes not measured in the previous cycle go first, so that the budget rotates between them.
+	slices.SortStableFunc(tracked, func(a, b *ProcessSnapshot) int {
+		_, measuredA := d.results[a.Target]
+		_, measuredB := d.results[b.Target]
+		switch {
+		case measuredA == measuredB:
+			return 0
+		case measuredB:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	budget := d.maxPages
+	exhausted := false
+	for _, ps := range tracked {
+
+		// The first cycle of a process only resets the bits, since pages written before it are unknown.
+		if last, found := d.cleared[ps.Target]; found && !exhausted {
+			dirty, err := d.countDirtyPages(ps, &budget)
+			if errors.Is(err, errPageScanBudget) {
+				slog.Warn("Soft-dirty page budget exhausted, the process is measured partially and the remaining ones are not measured in this cycle", "pid", ps.Target.PID)
+				exhausted = true
+				err = nil
+			}
+			if err != nil {
+				slog.Error("Failed to read soft-dirty bits", "pid", ps.Target.PID, "error", err)
+			} else if len(dirty) > 0 {
+				elapsed := now.Sub(last).Seconds()
+				rates := make(map[string]float64)
+				for category, pages := range dirty {
+					rates[category] = float64(pages*d.pageSize) / elapsed
+				}
+				results[ps.Target] = rates
+			}
+		}
+
+		if err := d.clearSoftDirty(ps.Target.PID); err != nil {
+			slog.Error("Failed to reset soft-dirty bits", "pid", ps.Target.PID, "error", err)
+			continue
+		}
+		cleared[ps.Target] = now
+	}
+	d.cleared = cleared
+	d.results = results
+}
+
+// countDirtyPages counts the soft-dirty pages of the process by mapping category.
+// The pagemap is read in chunks charged to budget, and when the budget runs out
+// the counts of the pages read so far are returned with errPageScanBudget.
+func (d *SoftDirtyTracker) countDirtyPages(ps *ProcessSnapshot, budget *int64) (map[string]int64, error) {
+	pagemap, err := os.Open(filepath.Join(d.procPath, strconv.Itoa(ps.Target.PID), "pagemap"))
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+
+	dirty := make(map[string]int64)
+	for _, vma := range ps.VMAs {
+		category := MappingCategory(vma.Path)
+		if category == CategorySpecial {
+			continue
+		}
+		start, end, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		err = walkPagemap(pagemap, start, end, d.pageSize, func(_ uint64, entries []uint64) error {
+			if *budget <= 0 {
+				return errPageScanBudget
+			}
+			entries = entries[:min(int64(len(entries)), *budget)]
+			*budget -= int64(len(entries))
+
+			// Report categories without writes as zero, not missing.
+			if _, found := dirty[category]; !found {
+				dirty[category] = 0
+			}
+			for _, e := range entries {
+				if e&pagemapSoftDirty != 0 && e&(pagemapPresent|pagemapSwapped) != 0 {
+					dirty[category]++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return dirty, err
+		}
+	}
+	if *budget <= 0 {
+		return dirty, errPageScanBudget
+	}
+	return dirty, nil
+}
+
+// clearSoftDirty resets the soft-dirty bits of all pages of the process.
+func (d *SoftDirtyTracker) clearSoftDirty(pid int) error {
+	return os.WriteFile(filepath.Join(d.procPath, strconv.Itoa(pid), "clear_refs"), []byte(clearRefsSoftDirty), 0)
+}
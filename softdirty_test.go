@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSoftDirtyTrackerBudget(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	procPath := t.TempDir()
+	annotations := map[string]map[string]string{"uid": {SoftDirtyAnnotation: "true"}}
+
+	// Two processes with one written page out of two each. The budget covers a single process.
+	var snapshots []*ProcessSnapshot
+	for _, pid := range []int{1, 2} {
+		writePagemap(t, filepath.Join(procPath, fmt.Sprint(pid), "pagemap"), 16, []uint64{pagemapPresent | pagemapSoftDirty, pagemapPresent})
+		snapshots = append(snapshots, &ProcessSnapshot{
+			Target: Target{PodUID: "uid", PID: pid},
+			VMAs:   []*SmapsMapping{{AddrRange: fmt.Sprintf("%x-%x", 16*pageSize, 18*pageSize), Path: "[heap]"}},
+		})
+	}
+	d := NewSoftDirtyTracker(procPath, time.Second, 2)
+
+	now := time.Unix(1000, 0)
+	for cycle, wantMeasured := range []int{0, 1, 2, 1} {
+		for _, pid := range []int{1, 2} {
+			os.Remove(filepath.Join(procPath, fmt.Sprint(pid), "clear_refs"))
+		}
+		d.Update(snapshots, annotations, now)
+
+		// The bits of both processes are reset in every cycle, also when the budget runs out.
+		for _, pid := range []int{1, 2} {
+			if data, err := os.ReadFile(filepath.Join(procPath, fmt.Sprint(pid), "clear_refs")); err != nil || string(data) != clearRefsSoftDirty {
+				t.Errorf("cycle %d: soft-dirty bits of pid %d not reset", cycle, pid)
+			}
+		}
+		var measured []int
+		for _, ps := range snapshots {
+			if rate, found := ps.DirtyRate[CategoryHeap]; found {
+				measured = append(measured, ps.Target.PID)
+				if rate != float64(pageSize) {
+					t.Errorf("cycle %d: got rate %v for pid %d, want %v", cycle, rate, ps.Target.PID, pageSize)
+				}
+			}
+		}
+		// The first cycle only sets the baseline, and the others alternate between the processes.
+		if (wantMeasured == 0 && len(measured) != 0) || (wantMeasured != 0 && (len(measured) != 1 || measured[0] != wantMeasured)) {
+			t.Errorf("cycle %d: measured pids %v, want %d", cycle, measured, wantMeasured)
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestSoftDirtyTrackerMeasuresMappingsLargerThanBudget(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	procPath := t.TempDir()
+	annotations := map[string]map[string]string{"uid": {SoftDirtyAnnotation: "true"}}
+
+	// A heap of three chunks of pagemap, written to in the first chunk only, and a budget of one and a half chunks.
+	entries := make([]uint64, 3*pagemapChunkEntries)
+	entries[0] = pagemapPresent | pagemapSoftDirty
+	entries[pagemapChunkEntries+pagemapChunkEntries/2] = pagemapPresent | pagemapSoftDirty
+	writePagemap(t, filepath.Join(procPath, "1", "pagemap"), 16, entries)
+	snapshots := []*ProcessSnapshot{{
+		Target: Target{PodUID: "uid", PID: 1},
+		VMAs:   []*SmapsMapping{{AddrRange: fmt.Sprintf("%x-%x", 16*pageSize, (16+int64(len(entries)))*pageSize), Path: "[heap]"}},
+	}}
+	d := NewSoftDirtyTracker(procPath, time.Second, pagemapChunkEntries+pagemapChunkEntries/2)
+
+	now := time.Unix(1000, 0)
+	d.Update(snapshots, annotations, now)
+	d.Update(snapshots, annotations, now.Add(time.Second))
+	if rate, found := snapshots[0].DirtyRate[CategoryHeap]; !found || rate != float64(pageSize) {
+		t.Errorf("got rate %v, want %v from the pages read within the budget", snapshots[0].DirtyRate, pageSize)
+	}
+}
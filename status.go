@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ProcessStatus holds the kernel's per-process memory counters from
+// /proc/[pid]/status and /proc/[pid]/statm.
+type ProcessStatus struct {
+	// Fields from /proc/[pid]/status (all values in bytes, except Threads)
+	VmPeakBytes       int64
+	VmSizeBytes       int64
+	VmHWMBytes        int64
+	VmRSSBytes        int64
+	RssAnonBytes      int64
+	RssFileBytes      int64
+	RssShmemBytes     int64
+	VmPTEBytes        int64
+	VmSwapBytes       int64
+	HugetlbPagesBytes int64
+	Threads           int64
+
+	// Fields from /proc/[pid]/statm (converted from pages to bytes)
+	StatmSizeBytes     int64
+	StatmResidentBytes int64
+	StatmSharedBytes   int64
+	StatmTextBytes     int64
+	StatmDataBytes     int64
+}
+
+// ParseStatus parses the contents of a /proc/[pid]/status file.
+func ParseStatus(r io.Reader) (*ProcessStatus, error) {
+	status := &ProcessStatus{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		val, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Memory counters are reported in kB.
+		valBytes := val * 1024
+		switch key {
+		case "VmPeak":
+			status.VmPeakBytes = valBytes
+		case "VmSize":
+			status.VmSizeBytes = valBytes
+		case "VmHWM":
+			status.VmHWMBytes = valBytes
+		case "VmRSS":
+			status.VmRSSBytes = valBytes
+		case "RssAnon":
+			status.RssAnonBytes = valBytes
+		case "RssFile":
+			status.RssFileBytes = valBytes
+		case "RssShmem":
+			status.RssShmemBytes = valBytes
+		case "VmPTE":
+			status.VmPTEBytes = valBytes
+		case "VmSwap":
+			status.VmSwapBytes = valBytes
+		case "HugetlbPages":
+			status.HugetlbPagesBytes = valBytes
+		case "Threads":
+			status.Threads = val
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return status, nil
+}
+
+// ParseStatm parses the contents of a /proc/[pid]/statm file into status.
+// The values in statm are in pages and are converted to bytes using pageSize.
+func ParseStatm(r io.Reader, pageSize int64, status *ProcessStatus) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// size resident shared text lib data dt
+	fields := strings.Fields(string(data))
+	if len(fields) < 6 {
+		return fmt.Errorf("unexpected statm format: %q", strings.TrimSpace(string(data)))
+	}
+	var pages [6]int64
+	for i := range pages {
+		pages[i], err = strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected statm format: %w", err)
+		}
+	}
+	status.StatmSizeBytes = pages[0] * pageSize
+	status.StatmResidentBytes = pages[1] * pageSize
+	status.StatmSharedBytes = pages[2] * pageSize
+	status.StatmTextBytes = pages[3] * pageSize
+	status.StatmDataBytes = pages[5] * pageSize
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Target identifies a process running in a Kubernetes container.
+type Target struct {
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	PodUID      string `json:"pod_uid"`
+	Container   string `json:"container"`
+	ContainerID string `json:"container_id"`
+	PID         int    `json:"pid"`
+}
+
+// ProcessFilter selects processes by namespace, pod, container and command (comm).
+// A "*" in any field matches all values.
+type ProcessFilter struct {
+	Namespace string
+	Pod       string
+	Container string
+	Command   string
+}
+
+// ErrNoTargets is wrapped by the error of GetTargets when no process matches the filter.
+var ErrNoTargets = errors.New("no matching processes")
+
+// Finder discovers the processes to monitor and the metadata of their pods and containers.
+type Finder interface {
+	// GetTargets returns the processes matching the given filter. The error wraps
+	// ErrNoTargets when no process matches.
+	GetTargets(filter ProcessFilter) ([]Target, error)
+
+	// PodAnnotations returns the annotations of a pod found by the last GetTargets call.
+	PodAnnotations(podUID string) map[string]string
+
+	// GetContainerResources returns the memory resources the runtime reports for a container.
+	GetContainerResources(containerID string) (*ContainerResources, error)
+}
+
+// ParseProcessFilter parses a filter in the format namespace/pod/container/command.
+func ParseProcessFilter(s string) (ProcessFilter, error) {
+	parts := strings.SplitN(s, "/", 4)
+	if len(parts) != 4 {
+		return ProcessFilter{}, fmt.Errorf("invalid process filter %q: expected format namespace/pod/container/command", s)
+	}
+	return ProcessFilter{
+		Namespace: parts[0],
+		Pod:       parts[1],
+		Container: parts[2],
+		Command:   parts[3],
+	}, nil
+}
+
+// Matches checks if the process of the target with the given comm is selected by the filter.
+func (f ProcessFilter) Matches(t Target, comm string) bool {
+	matches := func(pattern, value string) bool { return pattern == "*" || pattern == value }
+	return matches(f.Namespace, t.Namespace) && matches(f.Pod, t.Pod) && matches(f.Container, t.Container) && matches(f.Command, comm)
+}
+
+// Intersect returns the filter of the processes selected by both f and other,
+// or false when no process can match both.
+func (f ProcessFilter) Intersect(other ProcessFilter) (ProcessFilter, bool) {
+	ok := true
+	intersect := func(a, b string) string {
+		switch {
+		case a == "*":
+			return b
+		case b == "*" || a == b:
+			return a
+		}
+		ok = false
+		return a
+	}
+	return ProcessFilter{
+		Namespace: intersect(f.Namespace, other.Namespace),
+		Pod:       intersect(f.Pod, other.Pod),
+		Container: intersect(f.Container, other.Container),
+		Command:   intersect(f.Command, other.Command),
+	}, ok
+}
+
+func (f ProcessFilter) String() string {
+	return strings.Join([]string{f.Namespace, f.Pod, f.Container, f.Command}, "/")
+}
+
+// ContainerRef identifies a Kubernetes container.
+type ContainerRef struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+}
+
+// ContainerRef returns the container the target process runs in.
+func (t Target) ContainerRef() ContainerRef {
+	return ContainerRef{Namespace: t.Namespace, Pod: t.Pod, Container: t.Container}
+}
+
+// PodRef identifies a Kubernetes pod.
+type PodRef struct {
+	Namespace string
+	Pod       string
+}
+
+// PodRef returns the pod the target process runs in.
+func (t Target) PodRef() PodRef {
+	return PodRef{Namespace: t.Namespace, Pod: t.Pod}
+}
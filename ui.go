@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles holds the web UI served at the root path.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the web UI, which lists the discovered processes and shows
+// a treemap of their memory using the JSON API.
+func uiHandler() http.Handler {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(root)
+}
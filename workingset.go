@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Working set temperatures reported by WorkingSet.
+const (
+	TemperatureHot  = "hot"
+	TemperatureWarm = "warm"
+	TemperatureCold = "cold"
+)
+
+// WorkingSet holds the resident bytes of a mapping by how recently they were accessed.
+type WorkingSet struct {
+	// HotBytes were accessed during the last interval.
+	HotBytes int64
+	// WarmBytes were not accessed during the last interval but within the cold threshold.
+	WarmBytes int64
+	// ColdBytes have not been accessed for at least the cold threshold.
+	ColdBytes int64
+}
+
+// Add accumulates another working set.
+func (w *WorkingSet) Add(o *WorkingSet) {
+	w.HotBytes += o.HotBytes
+	w.WarmBytes += o.WarmBytes
+	w.ColdBytes += o.ColdBytes
+}
+
+// vmaKey identifies a VMA of a process across intervals.
+type vmaKey struct {
+	target Target
+	start  uint64
+}
+
+// ageUnmarked is the age of a page that has not been marked idle yet, so its
+// idle bit does not tell whether it was accessed.
+const ageUnmarked = 255
+
+// idlePage is a present page whose idle bit is checked during a cycle.
+type idlePage struct {
+	ages   []uint8
+	index  int
+	pfn    uint64
+	path   string
+	byPath map[string]*WorkingSet
+}
+
+// WorkingSetEstimator estimates the working set of selected mappings using idle page tracking.
+//
+// Each cycle reads the idle bits of the pages marked in the previous cycle from
+// /sys/kernel/mm/page_idle/bitmap: pages whose bit has been cleared were
+// accessed. The pages are then marked idle again. For each virtual page the
+// estimator counts the cycles since it was last accessed, which classifies it
+// as hot, warm or cold. Pages are reported only from the cycle after they were
+// first marked. See https://docs.kernel.org/admin-guide/mm/idle_page_tracking.html
+//
+// Cycles run in the background, since the rate limit makes them last longer
+// than the scrape interval, and pollMetrics attaches the latest results.
+type WorkingSetEstimator struct {
+	procPath       string
+	bitmapPath     string
+	paths          *regexp.Regexp
+	coldCycles     int
+	maxPages       int64
+	pagesPerSecond float64
+	pageSize       int64
+
+	// ages is only accessed by the cycles.
+	ages map[vmaKey][]uint8
+
+	mu      sync.Mutex
+	results map[Target]map[string]*WorkingSet
+}
+
+// NewWorkingSetEstimator creates an estimator for the mappings whose path matches paths.
+// A cycle scans at most maxPages pages, at most pagesPerSecond pages per second.
+// Pages not accessed for coldCycles cycles are reported as cold.
+func NewWorkingSetEstimator(procPath, sysPath string, paths *regexp.Regexp, coldCycles int, maxPages int64, pagesPerSecond float64) *WorkingSetEstimator {
+	return &WorkingSetEstimator{
+		procPath:       procPath,
+		bitmapPath:     filepath.Join(sysPath, "kernel", "mm", "page_idle", "bitmap"),
+		paths:          paths,
+		coldCycles:     min(coldCycles, ageUnmarked-1),
+		maxPages:       maxPages,
+		pagesPerSecond: pagesPerSecond,
+		pageSize:       int64(os.Getpagesize()),
+		ages:           make(map[vmaKey][]uint8),
+		results:        make(map[Target]map[string]*WorkingSet),
+	}
+}
+
+// Run checks the idle bits of the latest snapshots collected by pollMetrics once per interval.
+func (e *WorkingSetEstimator) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+
+		snapshots := latestSnapshots.Load()
+		if snapshots == nil {
+			continue
+		}
+		if err := e.cycle(*snapshots); err != nil {
+			slog.Error("Failed to estimate working set", "error", err)
+		}
+	}
+}
+
+// Update attaches the latest working set estimates to the snapshots.
+func (e *WorkingSetEstimator) Update(snapshots []*ProcessSnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ps := range snapshots {
+		ps.WorkingSet = e.results[ps.Target]
+	}
+}
+
+// cycle checks and resets the idle bits of the selected mappings of all processes.
+func (e *WorkingSetEstimator) cycle(snapshots []*ProcessSnapshot) error {
+	bitmap, err := os.OpenFile(e.bitmapPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer bitmap.Close()
+
+	ages := make(map[vmaKey][]uint8)
+	results := make(map[Target]map[string]*WorkingSet)
+	var pages []idlePage
+	budget := e.maxPages
+	start := time.Now()
+	for _, ps := range snapshots {
+		byPath := make(map[string]*WorkingSet)
+		results[ps.Target] = byPath
+		collected, err := e.collectPages(ps, ages, byPath, &budget, start)
+		pages = append(pages, collected...)
+		if errors.Is(err, errPageScanBudget) {
+			slog.Warn("Working set page budget exhausted, remaining mappings are not analyzed", "pid", ps.Target.PID)
+			break
+		}
+		if err != nil {
+			slog.Error("Failed to read pagemap", "pid", ps.Target.PID, "error", err)
+		}
+	}
+
+	// The bitmap is accessed in 64-bit words, one bit per page frame.
+	words := make(map[uint64]uint64)
+	for _, p := range pages {
+		words[p.pfn/64] |= 1 << (p.pfn % 64)
+	}
+	idle := make(map[uint64]uint64, len(words))
+	var buf [8]byte
+	for word := range words {
+		if _, err := bitmap.ReadAt(buf[:], int64(word*8)); err != nil {
+			return err
+		}
+		idle[word] = binary.LittleEndian.Uint64(buf[:])
+	}
+
+	for _, p := range pages {
+		// Pages seen for the first time are only marked, as their idle bit was never set.
+		if p.ages[p.index] == ageUnmarked {
+			p.ages[p.index] = 0
+			continue
+		}
+		if idle[p.pfn/64]&(1<<(p.pfn%64)) == 0 {
+			p.ages[p.index] = 0
+		} else if p.ages[p.index] < ageUnmarked-1 {
+			p.ages[p.index]++
+		}
+		ws, found := p.byPath[p.path]
+		if !found {
+			ws = &WorkingSet{}
+			p.byPath[p.path] = ws
+		}
+		switch age := int(p.ages[p.index]); {
+		case age == 0:
+			ws.HotBytes += e.pageSize
+		case age < e.coldCycles:
+			ws.WarmBytes += e.pageSize
+		default:
+			ws.ColdBytes += e.pageSize
+		}
+	}
+
+	// Mark the pages idle for the next cycle. Writing zero bits has no effect.
+	for word, mask := range words {
+		binary.LittleEndian.PutUint64(buf[:], mask)
+		if _, err := bitmap.WriteAt(buf[:], int64(word*8)); err != nil {
+			return err
+		}
+	}
+
+	// State of VMAs that no longer exist is dropped.
+	e.ages = ages
+	e.mu.Lock()
+	e.results = results
+	e.mu.Unlock()
+	return nil
+}
+
+// collectPages reads the pagemap of the selected mappings of a process and returns its present pages.
+func (e *WorkingSetEstimator) collectPages(ps *ProcessSnapshot, ages map[vmaKey][]uint8, byPath map[string]*WorkingSet, budget *int64, cycleStart time.Time) ([]idlePage, error) {
+	pagemap, err := os.Open(filepath.Join(e.procPath, strconv.Itoa(ps.Target.PID), "pagemap"))
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+
+	var pages []idlePage
+	for _, vma := range ps.VMAs {
+		if !e.paths.MatchString(vma.Path) {
+			continue
+		}
+		if *budget <= 0 {
+			return pages, errPageScanBudget
+		}
+		start, end, err := vma.Addresses()
+		if err != nil {
+			continue
+		}
+		if n := int64(end-start) / e.pageSize; n > *budget {
+			end = start + uint64(*budget*e.pageSize)
+		}
+
+		// Keep the ages of a VMA as long as its size does not change.
+		key := vmaKey{target: ps.Target, start: start}
+		n := int((end - start) / uint64(e.pageSize))
+		vmaAges := e.ages[key]
+		if len(vmaAges) != n {
+			vmaAges = make([]uint8, n)
+			for i := range vmaAges {
+				vmaAges[i] = ageUnmarked
+			}
+		}
+		ages[key] = vmaAges
+
+		err = walkPagemap(pagemap, start, end, e.pageSize, func(addr uint64, entries []uint64) error {
+			first := int((addr - start) / uint64(e.pageSize))
+			for i, entry := range entries {
+				pfn := entry & pagemapPFNMask
+				if entry&pagemapPresent == 0 || pfn == 0 {
+					// The page frame may change before the page is present again.
+					vmaAges[first+i] = ageUnmarked
+					continue
+				}
+				pages = append(pages, idlePage{ages: vmaAges, index: first + i, pfn: pfn, path: vma.Path, byPath: byPath})
+			}
+			*budget -= int64(len(entries))
+			throttlePages(cycleStart, e.maxPages-*budget, e.pagesPerSecond)
+			return nil
+		})
+		if err != nil {
+			return pages, err
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestWorkingSetEstimatorCycle(t *testing.T) {
+	pageSize := int64(os.Getpagesize())
+	procPath := t.TempDir()
+	sysPath := t.TempDir()
+
+	// Pages at pfn 100, 101 and 102, followed by a page that is not present.
+	const startPage = 16
+	writePagemap(t, filepath.Join(procPath, "42", "pagemap"), startPage, []uint64{
+		pagemapPresent | 100,
+		pagemapPresent | 101,
+		pagemapPresent | 102,
+		0,
+	})
+	bitmapPath := filepath.Join(sysPath, "kernel", "mm", "page_idle", "bitmap")
+	if err := os.MkdirAll(filepath.Dir(bitmapPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bitmapPath, make([]byte, 32), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// access simulates the kernel clearing the idle bit of an accessed page frame.
+	access := func(pfn uint64) {
+		data, err := os.ReadFile(bitmapPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		word := binary.LittleEndian.Uint64(data[pfn/64*8:])
+		binary.LittleEndian.PutUint64(data[pfn/64*8:], word&^(1<<(pfn%64)))
+		if err := os.WriteFile(bitmapPath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := func() *ProcessSnapshot {
+		return &ProcessSnapshot{
+			Target: Target{PID: 42},
+			VMAs: []*SmapsMapping{{
+				AddrRange: fmt.Sprintf("%x-%x", startPage*pageSize, (startPage+4)*pageSize),
+				Path:      "[heap]",
+			}},
+		}
+	}
+
+	e := NewWorkingSetEstimator(procPath, sysPath, regexp.MustCompile(`^\[heap\]$`), 2, 1024, 0)
+
+	// The first cycle only marks the pages idle.
+	ps := snapshot()
+	if err := e.cycle([]*ProcessSnapshot{ps}); err != nil {
+		t.Fatal(err)
+	}
+	e.Update([]*ProcessSnapshot{ps})
+	if len(ps.WorkingSet) != 0 {
+		t.Fatalf("working set reported before the pages were marked: %+v", ps.WorkingSet["[heap]"])
+	}
+
+	tests := []struct {
+		accessed []uint64
+		want     WorkingSet
+	}{
+		{accessed: []uint64{100}, want: WorkingSet{HotBytes: pageSize, WarmBytes: 2 * pageSize}},
+		{accessed: nil, want: WorkingSet{WarmBytes: pageSize, ColdBytes: 2 * pageSize}},
+		{accessed: []uint64{101}, want: WorkingSet{HotBytes: pageSize, ColdBytes: 2 * pageSize}},
+	}
+	for i, tt := range tests {
+		for _, pfn := range tt.accessed {
+			access(pfn)
+		}
+		ps := snapshot()
+		if err := e.cycle([]*ProcessSnapshot{ps}); err != nil {
+			t.Fatal(err)
+		}
+		e.Update([]*ProcessSnapshot{ps})
+		got := ps.WorkingSet["[heap]"]
+		if got == nil || *got != tt.want {
+			t.Errorf("cycle %d: got %+v, want %+v", i+2, got, tt.want)
+		}
+	}
+}